@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/apsdehal/go-logger"
+)
+
+var adminListen = os.Getenv("ADMIN_LISTEN") // local admin API, e.g. "localhost:6061"
+var maxConcurrency, maxConcurrencyErr = strconv.Atoi(getEnv("MAX_CONCURRENCY", "0"))
+var probeRate, probeRateErr = strconv.Atoi(getEnv("PROBE_RATE", "0"))
+
+// settings are limits of scanning which could be changed at runtime with admin API
+type settings struct {
+	Paused         bool    `json:"paused"`
+	MaxLoad        float64 `json:"max_load"`
+	MaxConcurrency int     `json:"max_concurrency"` // 0 - limited by load only
+	Rate           int     `json:"rate"`            // probes per second, 0 - unlimited
+	LogLevel       int     `json:"log_level"`
+}
+
+func (s settings) validate() error {
+	switch {
+	case s.MaxLoad <= 0 || s.MaxLoad > 100:
+		return fmt.Errorf("max_load %v should be between 0 and 100", s.MaxLoad)
+	case s.MaxConcurrency < 0 || s.MaxConcurrency > grandMaxGoroutines:
+		return fmt.Errorf("max_concurrency %d should be between 0 and %d", s.MaxConcurrency, grandMaxGoroutines)
+	case s.Rate < 0:
+		return fmt.Errorf("negative rate %d", s.Rate)
+	case s.LogLevel < int(logger.CriticalLevel) || s.LogLevel > int(logger.DebugLevel):
+		return fmt.Errorf("log_level %d should be between %d and %d", s.LogLevel, logger.CriticalLevel, logger.DebugLevel)
+	}
+	return nil
+}
+
+// tuning keeps current settings. Zero value starts with settings from environment.
+type tuning struct {
+	mtx      sync.Mutex
+	settings settings
+	changed  chan struct{} // closed and replaced on every change
+}
+
+// get returns current settings and channel closed when they change
+func (t *tuning) get() (settings, <-chan struct{}) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.init()
+	return t.settings, t.changed
+}
+
+// update changes settings unless f fails
+func (t *tuning) update(f func(*settings) error) (settings, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.init()
+
+	s := t.settings
+	if err := f(&s); err != nil {
+		return t.settings, err
+	}
+	t.settings = s
+	close(t.changed)
+	t.changed = make(chan struct{})
+	return s, nil
+}
+
+// init should be called with mutex held
+func (t *tuning) init() {
+	if t.changed != nil {
+		return
+	}
+	t.settings = settings{MaxLoad: maxLoad, MaxConcurrency: maxConcurrency, Rate: probeRate, LogLevel: logLevel}
+	t.changed = make(chan struct{})
+}
+
+// flush asks sendStat to save buffered results now
+func (env *envStruct) flush() bool {
+	select {
+	case env.flushCh <- struct{}{}:
+		return true
+	default:
+		return env.flushCh != nil // flush is requested already
+	}
+}
+
+// adminHandler serves admin API, every endpoint replies with current settings:
+//
+//	GET  /admin/settings - current settings
+//	POST /admin/settings - change settings present in JSON body
+//	POST /admin/pause    - stop starting new probes
+//	POST /admin/resume   - continue probing
+//	POST /admin/flush    - save buffered results now
+func (env *envStruct) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/settings", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			s, _ := env.tuning.get()
+			encodeSettings(w, s)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s, err := env.tuning.update(func(s *settings) error {
+			if err := json.Unmarshal(body, s); err != nil {
+				return err
+			}
+			return s.validate()
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		env.log.SetLogLevel(logger.LogLevel(s.LogLevel))
+		env.log.Noticef("Settings changed: %+v", s)
+		encodeSettings(w, s)
+	})
+	mux.HandleFunc("/admin/pause", env.adminPause(true))
+	mux.HandleFunc("/admin/resume", env.adminPause(false))
+	mux.HandleFunc("/admin/flush", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !env.flush() {
+			http.Error(w, "scan is not running", http.StatusServiceUnavailable)
+			return
+		}
+		env.log.Noticef("Flush requested")
+		s, _ := env.tuning.get()
+		encodeSettings(w, s)
+	})
+	return mux
+}
+
+func (env *envStruct) adminPause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s, _ := env.tuning.update(func(s *settings) error {
+			s.Paused = paused
+			return nil
+		})
+		if paused {
+			env.log.Noticef("Scanning paused")
+		} else {
+			env.log.Noticef("Scanning resumed")
+		}
+		encodeSettings(w, s)
+	}
+}
+
+func encodeSettings(w http.ResponseWriter, s settings) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apsdehal/go-logger"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func TestAdminHandler(t *testing.T) {
+	mockEnv := &envStruct{}
+	mockEnv.log, _ = logger.New("worldping", 0, os.Stdout)
+	handler := mockEnv.adminHandler()
+
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+		exp    settings
+	}{
+		{method: http.MethodGet, path: "/admin/settings", code: http.StatusOK,
+			exp: settings{MaxLoad: maxLoad, LogLevel: logLevel}},
+		{method: http.MethodPost, path: "/admin/settings", body: `{"rate": 1000, "max_concurrency": 50}`, code: http.StatusOK,
+			exp: settings{MaxLoad: maxLoad, MaxConcurrency: 50, Rate: 1000, LogLevel: logLevel}},
+		{method: http.MethodPost, path: "/admin/settings", body: `{"max_load": 0.5, "log_level": 6}`, code: http.StatusOK,
+			exp: settings{MaxLoad: 0.5, MaxConcurrency: 50, Rate: 1000, LogLevel: 6}},
+		// wrong values are not applied
+		{method: http.MethodPost, path: "/admin/settings", body: `{"rate": 10, "max_load": 0}`, code: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/admin/settings", body: `{"log_level": 7}`, code: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/admin/settings", body: `{`, code: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/admin/pause", code: http.StatusOK,
+			exp: settings{Paused: true, MaxLoad: 0.5, MaxConcurrency: 50, Rate: 1000, LogLevel: 6}},
+		{method: http.MethodPost, path: "/admin/resume", code: http.StatusOK,
+			exp: settings{MaxLoad: 0.5, MaxConcurrency: 50, Rate: 1000, LogLevel: 6}},
+		{method: http.MethodGet, path: "/admin/pause", code: http.StatusMethodNotAllowed},
+		// scan is not running
+		{method: http.MethodPost, path: "/admin/flush", code: http.StatusServiceUnavailable},
+	}
+
+	for i, step := range steps {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(step.method, step.path, strings.NewReader(step.body)))
+		if rec.Code != step.code {
+			t.Errorf("Step %d FAILED: code %d (actual) != %d (expected): %s", i, rec.Code, step.code, rec.Body)
+			continue
+		}
+		if step.code != http.StatusOK {
+			continue
+		}
+		var actual settings
+		if err := json.NewDecoder(rec.Body).Decode(&actual); err != nil || actual != step.exp {
+			t.Errorf("Step %d FAILED: %+v (actual) != %+v (expected), %v", i, actual, step.exp, err)
+		}
+	}
+
+	mockEnv.flushCh = make(chan struct{}, 1)
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/flush", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Flush %d FAILED: code %d", i, rec.Code)
+		}
+	}
+	if len(mockEnv.flushCh) != 1 {
+		t.Errorf("FAILED: flush should be requested once")
+	}
+}
+
+func TestSchedulePause(t *testing.T) {
+	taskCh := make(chan types.Task)
+	resultCh := make(chan types.Task, 1)
+
+	mockEnv := &envStruct{pinger: mockPinger{}}
+	mockEnv.log, _ = logger.New("worldping", 0, os.Stdout)
+	var cancel context.CancelFunc
+	mockEnv.ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	pause := func(paused bool) {
+		mockEnv.tuning.update(func(s *settings) error {
+			s.Paused = paused
+			return nil
+		})
+	}
+
+	pause(true)
+	go mockEnv.schedule(taskCh, resultCh, make(chan float64))
+
+	select {
+	case taskCh <- types.Task{IP: 1}:
+		t.Fatalf("FAILED: paused scheduler should not take tasks")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	pause(false)
+	select {
+	case taskCh <- types.Task{IP: 1}:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("FAILED: resumed scheduler should take tasks")
+	}
+	if result := <-resultCh; result.IP != 1 {
+		t.Errorf("FAILED: wrong result %+v", result)
+	}
+}
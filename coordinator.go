@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/coordinator"
+	"github.com/nanorobocop/worldping/pkg/probe"
+	"github.com/nanorobocop/worldping/pkg/utils"
+)
+
+// version is reported to coordinator, set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+var coordinatorURL = os.Getenv("COORDINATOR_URL") // workers talk to coordinator instead of database if set
+var coordinatorListen = getEnv("COORDINATOR_LISTEN", ":8080")
+var leaseTimeout, leaseTimeoutErr = time.ParseDuration(getEnv("LEASE_TIMEOUT", "10m"))
+var workerID = os.Getenv("WORKER_ID") // assigned by coordinator if empty
+var heartbeatInterval, heartbeatIntervalErr = time.ParseDuration(getEnv("HEARTBEAT_INTERVAL", "30s"))
+var staleAfter, staleAfterErr = time.ParseDuration(getEnv("STALE_AFTER", "2m"))
+var vantages, vantagesErr = strconv.Atoi(getEnv("VANTAGES", "1")) // vantage points scanning every range
+
+// Heartbeater reports worker stats to coordinator
+type Heartbeater interface {
+	Heartbeat(coordinator.HeartbeatRequest) error
+}
+
+// newCoordinatorClient describes this worker for registration at coordinator
+func newCoordinatorClient() (*coordinator.Client, error) {
+	if heartbeatIntervalErr != nil || heartbeatInterval <= 0 {
+		return nil, fmt.Errorf("wrong value HEARTBEAT_INTERVAL: %v", getEnv("HEARTBEAT_INTERVAL", ""))
+	}
+	names, err := probe.Names(probes)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+
+	return &coordinator.Client{
+		URL:          coordinatorURL,
+		ID:           workerID,
+		Vantage:      vantage,
+		Hostname:     hostname,
+		Version:      version,
+		Capabilities: append([]string{probe.Echo}, names...),
+	}, nil
+}
+
+// coordinator serves API for workers until shutdown
+func (env *envStruct) coordinator() {
+	if leaseTimeoutErr != nil || leaseTimeout <= 0 {
+		env.log.Fatalf("Wrong value LEASE_TIMEOUT: %v", getEnv("LEASE_TIMEOUT", ""))
+	}
+	if staleAfterErr != nil || staleAfter <= 0 {
+		env.log.Fatalf("Wrong value STALE_AFTER: %v", getEnv("STALE_AFTER", ""))
+	}
+	if vantagesErr != nil || vantages < 1 {
+		env.log.Fatalf("Wrong value VANTAGES: %v", getEnv("VANTAGES", ""))
+	}
+
+	server := &http.Server{
+		Addr:    coordinatorListen,
+		Handler: &coordinator.Server{DB: env.dbConn, LeaseTimeout: leaseTimeout, StaleAfter: staleAfter, Vantages: vantages},
+	}
+
+	go func() {
+		<-env.ctx.Done()
+		env.log.Noticef("Received signal for shutdown.")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	env.log.Noticef("Coordinator listening on %s", coordinatorListen)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		env.log.Fatalf("Coordinator failed: %v", err)
+	}
+}
+
+// heartbeat periodically reports probing rate and progress until shutdown
+func (env *envStruct) heartbeat(hb Heartbeater) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	last, lastTime := atomic.LoadUint64(&env.probed), time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			probed := atomic.LoadUint64(&env.probed)
+			req := coordinator.HeartbeatRequest{
+				Rate:     float64(probed-last) / now.Sub(lastTime).Seconds(),
+				Probed:   probed,
+				Progress: atomic.LoadUint32(&env.progress),
+			}
+			if err := hb.Heartbeat(req); err != nil {
+				env.log.Errorf("Cannot send heartbeat: %v", err)
+			}
+			last, lastTime = probed, now
+		case <-env.ctx.Done():
+			return
+		}
+	}
+}
+
+// fleet prints workers registered at coordinator
+func (env *envStruct) fleet() {
+	if coordinatorURL == "" {
+		env.log.Fatalf("COORDINATOR_URL is not set")
+	}
+	workers, err := (&coordinator.Client{URL: coordinatorURL}).Workers()
+	if err != nil {
+		env.log.Fatalf("Cannot get workers from coordinator: %v", err)
+	}
+	printFleet(os.Stdout, workers, time.Now())
+}
+
+// printFleet writes table of workers, stale ones are marked
+func printFleet(out io.Writer, workers []coordinator.Worker, now time.Time) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tVANTAGE\tHOSTNAME\tVERSION\tPROBES\tLEASE\tPROGRESS\tRATE\tPROBED\tLAST SEEN\tSTATUS")
+	for _, wr := range workers {
+		lease := "-"
+		if wr.Lease != nil {
+			lease = fmt.Sprintf("%s-%s", utils.IPToStr(wr.Lease.Start), utils.IPToStr(wr.Lease.End))
+		}
+		vantage := wr.Vantage
+		if vantage == "" {
+			vantage = "-"
+		}
+		status := "ok"
+		if wr.Stale {
+			status = "stale"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%.1f/s\t%d\t%s ago\t%s\n",
+			wr.ID, vantage, wr.Hostname, wr.Version, strings.Join(wr.Capabilities, ","), lease,
+			utils.IPToStr(wr.Progress), wr.Rate, wr.Probed, now.Sub(wr.LastSeen).Round(time.Second), status)
+	}
+	w.Flush()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apsdehal/go-logger"
+	"github.com/nanorobocop/worldping/pkg/coordinator"
+)
+
+type mockHeartbeater chan coordinator.HeartbeatRequest
+
+func (hb mockHeartbeater) Heartbeat(req coordinator.HeartbeatRequest) error {
+	hb <- req
+	return nil
+}
+
+func TestHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockEnv := &envStruct{ctx: ctx, probed: 10, progress: 42}
+	mockEnv.log, _ = logger.New("worldping", 0, os.Stdout)
+
+	interval := heartbeatInterval
+	heartbeatInterval = 10 * time.Millisecond
+	defer func() { heartbeatInterval = interval }()
+
+	hb := make(mockHeartbeater, 1)
+	go mockEnv.heartbeat(hb)
+
+	req := <-hb
+	if req.Probed != 10 || req.Progress != 42 || req.Rate != 0 {
+		t.Errorf("FAILED: wrong heartbeat %+v", req)
+	}
+}
+
+func TestPrintFleet(t *testing.T) {
+	now := time.Now()
+	workers := []coordinator.Worker{
+		{ID: "a", Hostname: "host-a", Capabilities: []string{"echo", "tcp-ack"}, LastSeen: now, Rate: 1.5, Progress: 1 << 24,
+			Lease: &coordinator.Lease{Start: 1 << 24, End: 2<<24 - 1}},
+		{ID: "b", LastSeen: now.Add(-time.Hour), Stale: true},
+	}
+
+	var b bytes.Buffer
+	printFleet(&b, workers, now)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("FAILED: wrong table:\n%s", b.String())
+	}
+	if !strings.Contains(lines[1], "1.0.0.0-1.255.255.255") || !strings.Contains(lines[1], "echo,tcp-ack") || !strings.HasSuffix(lines[1], "ok") {
+		t.Errorf("FAILED: wrong line %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "1h0m0s ago") || !strings.HasSuffix(lines[2], "stale") {
+		t.Errorf("FAILED: wrong line %q", lines[2])
+	}
+}
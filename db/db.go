@@ -4,12 +4,11 @@ import (
 	"database/sql"
 	"fmt"
 	"math"
-	"strings"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/nanorobocop/worldping/pkg/types"
 	"github.com/nanorobocop/worldping/pkg/utils"
-
-	_ "github.com/lib/pq" // driver
 )
 
 // DB implements interface for database (Postgres initially)
@@ -19,14 +18,85 @@ type DB interface {
 	CreateTable() error
 	GetMaxIP() (uint32, error)
 	GetOldestIP() (uint32, error)
+	UnitSize() uint32
 	Save(types.Tasks) error
 	Close() error
 }
 
-// Postgres contains connection to Postgres
+// TraceStore implements storage of traceroutes.
+// It is optional for DB implementations.
+type TraceStore interface {
+	GetRepresentatives() ([]uint32, error)
+	SaveTrace(types.Trace) error
+}
+
+// PTRStore implements storage of reverse DNS records.
+// It is optional for DB implementations.
+type PTRStore interface {
+	GetPTRTargets(start, end uint32, maxAge time.Duration) ([]uint32, error)
+	SavePTR([]types.PTR) error
+}
+
+// BatchStore implements idempotent saving of identified batches.
+// It is optional for DB implementations.
+type BatchStore interface {
+	// SaveBatch saves results and records batch ID in the same transaction.
+	// Batch saved before is skipped and reported as duplicate.
+	SaveBatch(types.Batch) (duplicate bool, err error)
+}
+
+// RollupStore implements aggregates of results per /24 and /16 prefix, updated as results are saved.
+// It is optional for DB implementations.
+type RollupStore interface {
+	RebuildRollups() error
+	GetRollups(prefix int, start, end uint32) ([]types.Rollup, error)
+}
+
+// ResultReader implements reading of saved results, e.g. for snapshots.
+// It is optional for DB implementations.
+type ResultReader interface {
+	// ReadResults passes results of vantage point with addresses in range [start, end] to f
+	// in ascending order of addresses, stops at first error
+	ReadResults(vantage string, start, end uint32, f func(types.Task) error) error
+}
+
+// RescanQueue implements priority queue of prefixes to rescan.
+// It is optional for DB implementations.
+type RescanQueue interface {
+	EnqueueRescan(types.Rescan) (int64, error)
+	NextRescan() (rescan types.Rescan, ok bool, err error)
+	FinishRescan(id int64) error
+	ReleaseRescan(id int64) error
+	GetRescans() ([]types.Rescan, error)
+}
+
+// RescanTimeout is how long rescan could be unfinished by worker
+// before it is handed out again
+const RescanTimeout = time.Hour
+
+// DefaultUnitPrefix is prefix length of work unit: /8 range = 2^24 addresses
+const DefaultUnitPrefix = 8
+
+// MaxUnitPrefix limits amount of tracked ranges (2^20 for /20)
+const MaxUnitPrefix = 20
+
+// MaxPartitionPrefix limits amount of partitions of results table (2^12 for /12)
+const MaxPartitionPrefix = 12
+
+// Postgres contains connection to Postgres.
+// Scan progress is tracked per work unit of UnitPrefix length (DefaultUnitPrefix if 0).
+// Addresses of results are stored as signed int (unsigned address reinterpreted, see utils.UintToInt),
+// or as inet if InetIP is set: existing table is converted by migration, which could not be reverted.
+// Results table is partitioned by address ranges of PartitionPrefix length if it is not 0, the same way:
+// inet conversion should be enabled before partitioning or together with it.
 type Postgres struct {
 	c                                                       *sql.DB
 	DBAddr, DBPort, DBName, DBUsername, DBPassword, DBTable string
+	UnitPrefix                                              int
+	InetIP                                                  bool
+	PartitionPrefix                                         int
+
+	inet bool // addresses are stored as inet, detected after migrations
 }
 
 // Open opens db connection
@@ -41,50 +111,433 @@ func (db *Postgres) Ping() error {
 	return db.c.Ping()
 }
 
-// CreateTable creates table if not exists
+// CreateTable applies schema migrations (see Migrate) and fills in work units
 func (db *Postgres) CreateTable() (err error) {
-	_, err = db.c.Query(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (ip int PRIMARY KEY, ping bool, timestamp timestamp);`, db.DBTable))
+	if err = db.Migrate(); err != nil {
+		return err
+	}
+	// table converted once keeps inet, even if option is not set anymore
+	var dataType string
+	stmt := `SELECT data_type FROM information_schema.columns WHERE table_name = $1 AND column_name = 'ip';`
+	if err = db.c.QueryRow(stmt, db.DBTable).Scan(&dataType); err != nil {
+		return err
+	}
+	db.inet = dataType == "inet"
+	return db.fillRanges()
+}
+
+// ipToColumn converts SQL expression of signed int address to type of address columns of results
+func (db *Postgres) ipToColumn(expr string) string {
+	if !db.inet {
+		return expr
+	}
+	return fmt.Sprintf("('0.0.0.0'::inet + (%s::bigint & 4294967295))", expr)
+}
+
+// ipFromColumn converts SQL expression of address column of results to signed int
+func (db *Postgres) ipFromColumn(expr string) string {
+	if !db.inet {
+		return expr
+	}
+	// low 32 bits of unsigned address are read as signed int
+	return fmt.Sprintf("((%s - '0.0.0.0'::inet)::bit(32)::int)", expr)
+}
+
+// fillRanges fills table with time of last save per work unit with all units of configured size,
+// unless they exist already.
+// Units of different sizes are tracked independently, so unit size could be changed any time.
+// Initial timestamps are taken from first address of unit (how oldest unit was selected before),
+// the latest one if it was scanned from several vantage points.
+func (db *Postgres) fillRanges() (err error) {
+	stmt := fmt.Sprintf(`INSERT INTO %s_ranges (start, size, timestamp)
+		SELECT range, $1, (SELECT max(timestamp) FROM %s WHERE ip = %s) FROM generate_series(%d, %d, $1) AS range
+		WHERE NOT EXISTS (SELECT 1 FROM %s_ranges WHERE size = $1);`, db.DBTable, db.DBTable, db.ipToColumn("range"), math.MinInt32, math.MaxInt32, db.DBTable)
+	_, err = db.c.Exec(stmt, int64(db.UnitSize()))
 	return err
 }
 
 // DropTable drops table (for tests)
 func (db *Postgres) DropTable() (err error) {
-	_, err = db.c.Query(fmt.Sprintf(`DROP TABLE %s;`, db.DBTable))
+	_, err = db.c.Exec(fmt.Sprintf(`DROP TABLE %[1]s, %[1]s_traces, %[1]s_ptr, %[1]s_ranges, %[1]s_rescans, %[1]s_batches, %[1]s_rollup24, %[1]s_rollup16, %[1]s_schema_version;`, db.DBTable))
 	return err
 }
 
+// UnitSize returns amount of addresses in work unit
+func (db *Postgres) UnitSize() uint32 {
+	return unitSize(db.UnitPrefix)
+}
+
+// unitSize returns amount of addresses in work unit of prefix length (DefaultUnitPrefix if 0)
+func unitSize(prefix int) uint32 {
+	if prefix == 0 {
+		prefix = DefaultUnitPrefix
+	}
+	return 1 << uint(32-prefix)
+}
+
 // GetMaxIP return maximum IP in db
 func (db *Postgres) GetMaxIP() (maxIP uint32, err error) {
 	var signed int32
-	err = db.c.QueryRow(fmt.Sprintf("SELECT MAX(ip) from %s;", db.DBTable)).Scan(&signed)
+	err = db.c.QueryRow(fmt.Sprintf("SELECT %s from %s;", db.ipFromColumn("MAX(ip)"), db.DBTable)).Scan(&signed)
 	return *utils.IntToUint(signed), err
 }
 
-// GetOldestIP returns oldest IP from db
+// GetOldestIP returns first IP of work unit saved longest time ago (or never)
 func (db *Postgres) GetOldestIP() (oldestIP uint32, err error) {
 	var signed int32
-	// SELECT range FROM generate_series(-2147483648, 2147483647, 16777216) AS range LEFT OUTER JOIN worldping on (range = ip) ORDER BY timestamp NULLS FIRST LIMIT 1;
-	stmt := fmt.Sprintf("SELECT range FROM generate_series(%d, %d, %d) AS range LEFT OUTER JOIN %s on (range = ip) ORDER BY timestamp NULLS FIRST LIMIT 1;", math.MinInt32, math.MaxInt32, 1<<24, db.DBTable)
-	err = db.c.QueryRow(stmt).Scan(&signed)
+	// SELECT start FROM worldping_ranges WHERE size = 16777216 ORDER BY timestamp NULLS FIRST, start LIMIT 1;
+	stmt := fmt.Sprintf("SELECT start FROM %s_ranges WHERE size = $1 ORDER BY timestamp NULLS FIRST, start LIMIT 1;", db.DBTable)
+	err = db.c.QueryRow(stmt, int64(db.UnitSize())).Scan(&signed)
 	return *utils.IntToUint(signed), err
 }
 
-// Save commits information to db
+// Release marks work unit containing next as never saved, so unit interrupted by drain
+// is handed out again before scanned ones, from its first address
+func (db *Postgres) Release(next uint32) (err error) {
+	start := next &^ (db.UnitSize() - 1)
+	stmt := fmt.Sprintf("UPDATE %s_ranges SET timestamp = NULL WHERE size = $1 AND start = $2;", db.DBTable)
+	_, err = db.c.Exec(stmt, int64(db.UnitSize()), *utils.UintToInt(start))
+	return err
+}
+
+// Save commits information to db, one row per IP and vantage point.
+// Results are passed as one array per column, so amount of query parameters
+// does not depend on batch size (Postgres allows 65535 parameters at most).
+// ICMP error columns are NULL when no error was received,
+// reply_from is NULL unless reply came from foreign source.
+// Results are saved with time they were probed (now if it is zero), result is not replaced by older one,
+// so imported history does not overwrite newer scans.
+// Work units containing results are marked as saved at time of their latest result, unless they were saved later.
 func (db *Postgres) Save(results types.Tasks) (err error) {
+	txn, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
 
-	valueStrings := make([]string, 0, len(results))
-	valueArgs := make([]interface{}, 0, len(results)*2)
+	if err = db.save(txn, results); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// SaveBatch saves results as Save does, batch ID is recorded in <table>_batches.
+// Concurrent retry of the same batch waits for the first attempt and is skipped if it was committed.
+func (db *Postgres) SaveBatch(batch types.Batch) (duplicate bool, err error) {
+	txn, err := db.c.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer txn.Rollback()
+
+	stmt := fmt.Sprintf(`INSERT INTO %s_batches (worker, started, seq, size, timestamp) VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		ON CONFLICT DO NOTHING;`, db.DBTable)
+	res, err := txn.Exec(stmt, batch.ID.Worker, batch.ID.Started, int64(batch.ID.Seq), len(batch.Results))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return true, nil // committed by previous attempt
+	}
+
+	if err = db.save(txn, batch.Results); err != nil {
+		return false, err
+	}
+	return false, txn.Commit()
+}
+
+// save upserts results and marks their work units in transaction
+func (db *Postgres) save(txn *sql.Tx, results types.Tasks) (err error) {
+	ips := make([]int32, len(results))
+	vantages := make([]string, len(results))
+	pings := make([]bool, len(results))
+	probes := make([]string, len(results))
+	icmpTypes := make([]int32, len(results))
+	icmpCodes := make([]int32, len(results))
+	routers := make([]int32, len(results))
+	replies := make([]int32, len(results))
+	anomalies := make([]int32, len(results))
+	replyFroms := make([]int32, len(results))
+	rtts := make([]float64, len(results))
+	sources := make([]string, len(results))
+	times := make([]float64, len(results)) // unix time, 0 - now
+	addrs := make([]uint32, len(results))
+	unitTimes := make(map[uint32]float64)
+	mask := ^(db.UnitSize() - 1)
 	for i, result := range results {
-		valueStrings = append(valueStrings, fmt.Sprintf("($%d, $%d, CURRENT_TIMESTAMP)", i*2+1, i*2+2)) // 0 -> ($1, $2), 1 -> ($3, $4), 2 -> ($5, $6)
-		valueArgs = append(valueArgs, utils.UintToInt(result.IP))
-		valueArgs = append(valueArgs, result.Ping)
+		if !result.Time.IsZero() {
+			times[i] = float64(result.Time.UnixNano()) / float64(time.Second)
+		}
+		unit := result.IP & mask
+		if t, ok := unitTimes[unit]; !ok || t != 0 && (times[i] == 0 || times[i] > t) {
+			unitTimes[unit] = times[i]
+		}
+		ips[i] = *utils.UintToInt(result.IP)
+		vantages[i] = result.Vantage
+		pings[i] = result.Ping
+		probes[i] = result.Probe
+		icmpTypes[i] = int32(result.ICMPType)
+		icmpCodes[i] = int32(result.ICMPCode)
+		routers[i] = *utils.UintToInt(result.Router)
+		replies[i] = int32(result.Replies)
+		anomalies[i] = int32(result.Anomalies)
+		replyFroms[i] = *utils.UintToInt(result.ReplyFrom)
+		rtts[i] = float64(result.RTT) / float64(time.Millisecond)
+		sources[i] = result.Source
+		addrs[i] = result.IP
+	}
+	var units []int32
+	var unitTimesArr []float64
+	for unit, t := range unitTimes {
+		units = append(units, *utils.UintToInt(unit))
+		unitTimesArr = append(unitTimesArr, t)
+	}
+	// worldping=> INSERT INTO worldping (ip, vantage, ping, probe, ...) SELECT ... FROM unnest('{1,2}'::int[], '{"",""}'::text[], '{t,f}'::bool[], ...) AS r (ip, vantage, ping, ...) ON CONFLICT (ip, vantage) DO UPDATE ...;
+	stmt := fmt.Sprintf(`INSERT INTO %[1]s AS t (ip, vantage, ping, probe, icmp_type, icmp_code, router, replies, anomalies, reply_from, rtt, source, timestamp)
+		SELECT %[2]s, vantage, ping, NULLIF(probe, ''), NULLIF(icmp_type, 0),
+			CASE WHEN icmp_type = 0 THEN NULL ELSE icmp_code END,
+			CASE WHEN icmp_type = 0 THEN NULL ELSE %[3]s END,
+			replies, anomalies, %[4]s, NULLIF(rtt, 0), NULLIF(source, ''),
+			COALESCE(to_timestamp(NULLIF(time, 0)), CURRENT_TIMESTAMP)
+		FROM unnest($1::int[], $2::text[], $3::bool[], $4::text[], $5::smallint[], $6::smallint[], $7::int[], $8::smallint[], $9::smallint[], $10::int[],
+			$11::real[], $12::text[], $13::float8[])
+			AS r (ip, vantage, ping, probe, icmp_type, icmp_code, router, replies, anomalies, reply_from, rtt, source, time)
+		ON CONFLICT (ip, vantage) DO UPDATE SET ping = excluded.ping, probe = excluded.probe,
+			icmp_type = excluded.icmp_type, icmp_code = excluded.icmp_code, router = excluded.router,
+			replies = excluded.replies, anomalies = excluded.anomalies, reply_from = excluded.reply_from,
+			rtt = excluded.rtt, source = excluded.source, timestamp = excluded.timestamp
+		WHERE t.timestamp IS NULL OR t.timestamp <= excluded.timestamp`,
+		db.DBTable, db.ipToColumn("ip"), db.ipToColumn("router"), db.ipToColumn("NULLIF(reply_from, 0)"))
+
+	_, err = txn.Exec(stmt, pq.Array(ips), pq.Array(vantages), pq.Array(pings), pq.Array(probes), pq.Array(icmpTypes), pq.Array(icmpCodes), pq.Array(routers),
+		pq.Array(replies), pq.Array(anomalies), pq.Array(replyFroms), pq.Array(rtts), pq.Array(sources), pq.Array(times))
+	if err != nil {
+		return err
+	}
+	stmt = fmt.Sprintf(`INSERT INTO %s_ranges AS r (start, size, timestamp)
+		SELECT start, $2, COALESCE(to_timestamp(NULLIF(time, 0)), CURRENT_TIMESTAMP) FROM unnest($1::int[], $3::float8[]) AS u (start, time)
+		ON CONFLICT (size, start) DO UPDATE SET timestamp = GREATEST(r.timestamp, excluded.timestamp)`, db.DBTable)
+	if _, err = txn.Exec(stmt, pq.Array(units), int64(db.UnitSize()), pq.Array(unitTimesArr)); err != nil {
+		return err
+	}
+	return db.updateRollups(txn, addrs)
+}
+
+// GetRepresentatives returns one responsive IP per /24, most recently scanned one
+func (db *Postgres) GetRepresentatives() (ips []uint32, err error) {
+	// ip >> 8 is /24 prefix (arithmetic shift keeps prefixes distinct for negative ints)
+	stmt := fmt.Sprintf(`SELECT DISTINCT ON (ip >> 8) ip FROM (SELECT %s AS ip, timestamp FROM %s WHERE ping) AS r
+		ORDER BY ip >> 8, timestamp DESC;`, db.ipFromColumn("ip"), db.DBTable)
+	rows, err := db.c.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var signed int32
+		if err := rows.Scan(&signed); err != nil {
+			return nil, err
+		}
+		ips = append(ips, *utils.IntToUint(signed))
+	}
+	return ips, rows.Err()
+}
+
+// SaveTrace replaces stored traceroute to the target.
+// RTT is stored in milliseconds, router is NULL for silent hops.
+func (db *Postgres) SaveTrace(trace types.Trace) (err error) {
+	ttls := make([]int32, len(trace.Hops))
+	routers := make([]int32, len(trace.Hops))
+	rtts := make([]float64, len(trace.Hops))
+	for i, hop := range trace.Hops {
+		ttls[i] = int32(hop.TTL)
+		routers[i] = *utils.UintToInt(hop.Router)
+		rtts[i] = float64(hop.RTT) / float64(time.Millisecond)
+	}
+
+	txn, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	target := utils.UintToInt(trace.Target)
+	if _, err = txn.Exec(fmt.Sprintf("DELETE FROM %s_traces WHERE target = $1;", db.DBTable), target); err != nil {
+		return err
+	}
+	stmt := fmt.Sprintf(`INSERT INTO %s_traces (target, ttl, method, router, rtt, timestamp)
+		SELECT $1, ttl, $2, NULLIF(router, 0), CASE WHEN router = 0 THEN NULL ELSE rtt END, CURRENT_TIMESTAMP
+		FROM unnest($3::smallint[], $4::int[], $5::real[]) AS h (ttl, router, rtt)`, db.DBTable)
+	if _, err = txn.Exec(stmt, target, trace.Method, pq.Array(ttls), pq.Array(routers), pq.Array(rtts)); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// GetPTRTargets returns responsive IPs from range [start, end] without PTR lookup
+// during last maxAge. Range should not cross 128.0.0.0 (sign of int representation).
+func (db *Postgres) GetPTRTargets(start, end uint32, maxAge time.Duration) (ips []uint32, err error) {
+	ip := db.ipFromColumn("t.ip")
+	stmt := fmt.Sprintf(`SELECT DISTINCT %s AS ip FROM %s t LEFT OUTER JOIN %s_ptr p ON (p.ip = %s)
+		WHERE t.ping AND t.ip BETWEEN %s AND %s AND (p.timestamp IS NULL OR p.timestamp < CURRENT_TIMESTAMP - $3 * interval '1 second')
+		ORDER BY ip;`, ip, db.DBTable, db.DBTable, ip, db.ipToColumn("$1::int"), db.ipToColumn("$2::int"))
+	rows, err := db.c.Query(stmt, utils.UintToInt(start), utils.UintToInt(end), maxAge.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var signed int32
+		if err := rows.Scan(&signed); err != nil {
+			return nil, err
+		}
+		ips = append(ips, *utils.IntToUint(signed))
+	}
+	return ips, rows.Err()
+}
+
+// ReadResults passes results of vantage point with addresses in range [start, end] to f in ascending order.
+// Halves of address space are read separately, as int representation orders them the other way round.
+func (db *Postgres) ReadResults(vantage string, start, end uint32, f func(types.Task) error) (err error) {
+	stmt := fmt.Sprintf(`SELECT %s, ping, COALESCE(probe, ''), rtt, timestamp FROM %s
+		WHERE vantage = $1 AND ip BETWEEN %s AND %s ORDER BY ip;`,
+		db.ipFromColumn("ip"), db.DBTable, db.ipToColumn("$2::int"), db.ipToColumn("$3::int"))
+	for _, half := range [][2]uint32{{0, 1<<31 - 1}, {1 << 31, 1<<32 - 1}} {
+		from, to := half[0], half[1]
+		if start > from {
+			from = start
+		}
+		if end < to {
+			to = end
+		}
+		if from > to {
+			continue
+		}
+		if err = db.readResults(stmt, vantage, from, to, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Postgres) readResults(stmt, vantage string, start, end uint32, f func(types.Task) error) (err error) {
+	rows, err := db.c.Query(stmt, vantage, utils.UintToInt(start), utils.UintToInt(end))
+	if err != nil {
+		return err
 	}
-	// worldping=> INSERT INTO worldping  (ip, ping) VALUES (1, false),(2,false) ON CONFLICT (ip) DO UPDATE SET ping = excluded.ping ;
-	stmt := fmt.Sprintf("INSERT INTO %s (ip, ping, timestamp) VALUES %s ON CONFLICT (ip) DO UPDATE SET ping = excluded.ping, timestamp = CURRENT_TIMESTAMP", db.DBTable, strings.Join(valueStrings, ","))
-	_, err = db.c.Exec(stmt, valueArgs...)
+	defer rows.Close()
+
+	for rows.Next() {
+		var signed int32
+		var rtt sql.NullFloat64
+		var timestamp pq.NullTime
+		t := types.Task{Vantage: vantage}
+		if err = rows.Scan(&signed, &t.Ping, &t.Probe, &rtt, &timestamp); err != nil {
+			return err
+		}
+		t.IP = *utils.IntToUint(signed)
+		t.RTT = time.Duration(rtt.Float64 * float64(time.Millisecond))
+		t.Time = timestamp.Time
+		if err = f(t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// SavePTR commits reverse DNS records, hostname is NULL if there is no PTR
+func (db *Postgres) SavePTR(ptrs []types.PTR) (err error) {
+	ips := make([]int32, len(ptrs))
+	hostnames := make([]string, len(ptrs))
+	for i, ptr := range ptrs {
+		ips[i] = *utils.UintToInt(ptr.IP)
+		hostnames[i] = ptr.Hostname
+	}
+	stmt := fmt.Sprintf(`INSERT INTO %s_ptr (ip, hostname, timestamp)
+		SELECT ip, NULLIF(hostname, ''), CURRENT_TIMESTAMP FROM unnest($1::int[], $2::text[]) AS r (ip, hostname)
+		ON CONFLICT (ip) DO UPDATE SET hostname = excluded.hostname, timestamp = CURRENT_TIMESTAMP`, db.DBTable)
+	_, err = db.c.Exec(stmt, pq.Array(ips), pq.Array(hostnames))
 	return err
 }
 
+// EnqueueRescan adds prefix to rescan queue and returns its ID
+func (db *Postgres) EnqueueRescan(rescan types.Rescan) (id int64, err error) {
+	var deadline pq.NullTime
+	if !rescan.Deadline.IsZero() {
+		deadline = pq.NullTime{Time: rescan.Deadline, Valid: true}
+	}
+	stmt := fmt.Sprintf(`INSERT INTO %s_rescans (start, prefix, priority, deadline, created)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP) RETURNING id;`, db.DBTable)
+	err = db.c.QueryRow(stmt, utils.UintToInt(rescan.Start), rescan.Prefix, rescan.Priority, deadline).Scan(&id)
+	return id, err
+}
+
+// NextRescan takes prefix with highest priority (earliest deadline among equal priorities).
+// Prefixes past deadline are skipped, as well as prefixes taken by other workers less than RescanTimeout ago.
+// Concurrent callers get different prefixes.
+func (db *Postgres) NextRescan() (rescan types.Rescan, ok bool, err error) {
+	stmt := fmt.Sprintf(`UPDATE %s_rescans SET taken = CURRENT_TIMESTAMP WHERE id = (
+			SELECT id FROM %s_rescans
+			WHERE done IS NULL AND (taken IS NULL OR taken < CURRENT_TIMESTAMP - $1 * interval '1 second')
+				AND (deadline IS NULL OR deadline > CURRENT_TIMESTAMP)
+			ORDER BY priority DESC, deadline NULLS LAST, id LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING id, start, prefix, priority, deadline, created, taken, done;`, db.DBTable, db.DBTable)
+	rescan, err = scanRescan(db.c.QueryRow(stmt, RescanTimeout.Seconds()))
+	if err == sql.ErrNoRows {
+		return rescan, false, nil
+	}
+	return rescan, err == nil, err
+}
+
+// FinishRescan marks prefix as rescanned
+func (db *Postgres) FinishRescan(id int64) (err error) {
+	_, err = db.c.Exec(fmt.Sprintf(`UPDATE %s_rescans SET done = CURRENT_TIMESTAMP WHERE id = $1;`, db.DBTable), id)
+	return err
+}
+
+// ReleaseRescan gives unfinished prefix back to queue, so it is handed out again without waiting for RescanTimeout
+func (db *Postgres) ReleaseRescan(id int64) (err error) {
+	_, err = db.c.Exec(fmt.Sprintf(`UPDATE %s_rescans SET taken = NULL WHERE id = $1 AND done IS NULL;`, db.DBTable), id)
+	return err
+}
+
+// GetRescans returns queue, unfinished prefixes first in order they are handed out
+func (db *Postgres) GetRescans() (rescans []types.Rescan, err error) {
+	rows, err := db.c.Query(fmt.Sprintf(`SELECT id, start, prefix, priority, deadline, created, taken, done FROM %s_rescans
+		ORDER BY done DESC NULLS FIRST, priority DESC, deadline NULLS LAST, id;`, db.DBTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rescan, err := scanRescan(rows)
+		if err != nil {
+			return nil, err
+		}
+		rescans = append(rescans, rescan)
+	}
+	return rescans, rows.Err()
+}
+
+// scanRescan reads rescans row, NULL timestamps become zero time
+func scanRescan(row interface{ Scan(...interface{}) error }) (rescan types.Rescan, err error) {
+	var start int32
+	var deadline, created, taken, done pq.NullTime
+	if err = row.Scan(&rescan.ID, &start, &rescan.Prefix, &rescan.Priority, &deadline, &created, &taken, &done); err != nil {
+		return rescan, err
+	}
+	rescan.Start = *utils.IntToUint(start)
+	rescan.Deadline, rescan.Created, rescan.Taken, rescan.Done = deadline.Time, created.Time, taken.Time, done.Time
+	return rescan, nil
+}
+
 // Close closes connection to DB
 func (db *Postgres) Close() error {
 	return db.c.Close()
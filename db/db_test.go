@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/nanorobocop/worldping/pkg/types"
 )
@@ -102,3 +104,339 @@ func TestGetOldestIntegrational(t *testing.T) {
 		db.Close()
 	}
 }
+
+func TestGetOldestUnitPrefixIntegrational(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	db := Postgres{
+		DBAddr:     "127.0.0.1",
+		DBPort:     "5432",
+		DBName:     "postgres",
+		DBTable:    fmt.Sprintf("testdb_%d", rand.Intn(math.MaxInt16)),
+		DBUsername: "postgres",
+		DBPassword: "123456",
+		UnitPrefix: 16,
+	}
+	db.Open()
+	db.Ping()
+	db.CreateTable()
+	defer db.Close()
+
+	// first /16 units get saved, oldest one is never saved
+	steps := []struct {
+		results  types.Tasks
+		release  uint32
+		expected uint32
+	}{
+		{results: nil, expected: 0},
+		{results: types.Tasks{{IP: 5}, {IP: 1 << 16}}, expected: 2 << 16},
+		{results: types.Tasks{{IP: 2<<16 + 1}}, expected: 3 << 16},
+		// unit interrupted by drain is handed out again
+		{release: 1<<16 + 6, expected: 1 << 16},
+	}
+
+	for i, step := range steps {
+		if len(step.results) != 0 {
+			if err := db.Save(step.results); err != nil {
+				t.Fatalf("Step %d FAILED: cannot save: %v", i, err)
+			}
+		}
+		if step.release != 0 {
+			if err := db.Release(step.release); err != nil {
+				t.Fatalf("Step %d FAILED: cannot release: %v", i, err)
+			}
+		}
+		actual, err := db.GetOldestIP()
+		if err != nil || actual != step.expected {
+			t.Errorf("Step %d FAILED (db %s): actual %d (%v) != expected %d", i, db.DBTable, actual, err, step.expected)
+		}
+	}
+	db.DropTable()
+}
+
+func TestRescanQueueIntegrational(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	db := Postgres{
+		DBAddr:     "127.0.0.1",
+		DBPort:     "5432",
+		DBName:     "postgres",
+		DBTable:    fmt.Sprintf("testdb_%d", rand.Intn(math.MaxInt16)),
+		DBUsername: "postgres",
+		DBPassword: "123456",
+	}
+	db.Open()
+	db.Ping()
+	db.CreateTable()
+	defer db.Close()
+
+	now := time.Now()
+	rescans := []types.Rescan{
+		{Start: 1 << 24, Prefix: 24, Priority: 1},
+		{Start: 2 << 24, Prefix: 24, Priority: 5, Deadline: now.Add(-time.Hour)}, // expired
+		{Start: 1<<31 + 1<<24, Prefix: 16, Priority: 5},
+		{Start: 4 << 24, Prefix: 24, Priority: 1, Deadline: now.Add(time.Hour)},
+	}
+	for i, r := range rescans {
+		if _, err := db.EnqueueRescan(r); err != nil {
+			t.Fatalf("Rescan %d FAILED: cannot enqueue: %v", i, err)
+		}
+	}
+
+	// released prefix is handed out again
+	if r, ok, err := db.NextRescan(); err != nil || !ok || db.ReleaseRescan(r.ID) != nil {
+		t.Fatalf("FAILED: cannot take and release prefix: %+v, %v", r, err)
+	}
+
+	// highest priority first, then earliest deadline
+	for i, exp := range []uint32{1<<31 + 1<<24, 4 << 24, 1 << 24} {
+		r, ok, err := db.NextRescan()
+		if err != nil || !ok || r.Start != exp {
+			t.Errorf("Step %d FAILED: %+v, %v, %v (expected start %d)", i, r, ok, err, exp)
+			continue
+		}
+		if err := db.FinishRescan(r.ID); err != nil {
+			t.Errorf("Step %d FAILED: cannot finish: %v", i, err)
+		}
+	}
+	if r, ok, err := db.NextRescan(); err != nil || ok {
+		t.Errorf("FAILED: queue should be empty: %+v, %v", r, err)
+	}
+
+	list, err := db.GetRescans()
+	if err != nil || len(list) != 4 || !list[0].Done.IsZero() {
+		t.Errorf("FAILED: wrong queue %+v, %v", list, err)
+	}
+	db.DropTable()
+}
+
+func TestSaveBatchIntegrational(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	db := Postgres{
+		DBAddr:     "127.0.0.1",
+		DBPort:     "5432",
+		DBName:     "postgres",
+		DBTable:    fmt.Sprintf("testdb_%d", rand.Intn(math.MaxInt16)),
+		DBUsername: "postgres",
+		DBPassword: "123456",
+	}
+	db.Open()
+	db.Ping()
+	db.CreateTable()
+	defer db.Close()
+
+	id := types.BatchID{Worker: "worker-1", Started: time.Now(), Seq: 1}
+	batch := types.Batch{ID: id, Results: types.Tasks{{IP: 1, Ping: true}}}
+	if dup, err := db.SaveBatch(batch); dup || err != nil {
+		t.Errorf("FAILED: save: %v, %v", dup, err)
+	}
+
+	// retry is skipped, results of retry are not saved
+	batch.Results = types.Tasks{{IP: 1}}
+	if dup, err := db.SaveBatch(batch); !dup || err != nil {
+		t.Errorf("FAILED: retry should be duplicate: %v, %v", dup, err)
+	}
+	var ping bool
+	if err := db.c.QueryRow(fmt.Sprintf("SELECT ping FROM %s WHERE ip = 1;", db.DBTable)).Scan(&ping); err != nil || !ping {
+		t.Errorf("FAILED: retry should not overwrite results: %v, %v", ping, err)
+	}
+
+	// next batch of the same worker is saved
+	batch.ID.Seq = 2
+	if dup, err := db.SaveBatch(batch); dup || err != nil {
+		t.Errorf("FAILED: next batch: %v, %v", dup, err)
+	}
+	db.DropTable()
+}
+
+func TestInetIntegrational(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	db := Postgres{
+		DBAddr:     "127.0.0.1",
+		DBPort:     "5432",
+		DBName:     "postgres",
+		DBTable:    fmt.Sprintf("testdb_%d", rand.Intn(math.MaxInt16)),
+		DBUsername: "postgres",
+		DBPassword: "123456",
+		UnitPrefix: 16,
+	}
+	db.Open()
+	db.Ping()
+	defer db.Close()
+
+	// results saved before conversion are converted by migration
+	if err := db.CreateTable(); err != nil {
+		t.Fatalf("FAILED: create table: %v", err)
+	}
+	db.Save(types.Tasks{{IP: 1, Ping: true}})
+	db.InetIP = true
+	if err := db.CreateTable(); err != nil || !db.inet {
+		t.Fatalf("FAILED: inet migration: %v, inet %v", err, db.inet)
+	}
+	if err := db.Save(types.Tasks{{IP: 3355443201, Ping: true, ICMPType: 3, Router: 3355443202}}); err != nil { // 200.0.0.1
+		t.Fatalf("FAILED: save: %v", err)
+	}
+
+	if maxIP, err := db.GetMaxIP(); maxIP != 3355443201 || err != nil {
+		t.Errorf("FAILED: max IP %d, %v", maxIP, err)
+	}
+	var n int
+	stmt := fmt.Sprintf("SELECT count(*) FROM %s WHERE ip << '200.0.0.0/8' AND router = '200.0.0.2';", db.DBTable)
+	if err := db.c.QueryRow(stmt).Scan(&n); n != 1 || err != nil {
+		t.Errorf("FAILED: containment query returned %d, %v", n, err)
+	}
+	if ips, err := db.GetPTRTargets(3355443200, 3355443455, time.Hour); len(ips) != 1 || ips[0] != 3355443201 || err != nil {
+		t.Errorf("FAILED: PTR targets %v, %v", ips, err)
+	}
+	if ips, err := db.GetRepresentatives(); len(ips) != 2 || err != nil {
+		t.Errorf("FAILED: representatives %v, %v", ips, err)
+	}
+	db.DropTable()
+}
+
+func TestRollupsIntegrational(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	db := Postgres{
+		DBAddr:     "127.0.0.1",
+		DBPort:     "5432",
+		DBName:     "postgres",
+		DBTable:    fmt.Sprintf("testdb_%d", rand.Intn(math.MaxInt16)),
+		DBUsername: "postgres",
+		DBPassword: "123456",
+		UnitPrefix: 16,
+	}
+	db.Open()
+	db.Ping()
+	db.CreateTable()
+	defer db.Close()
+
+	// 200.0.0.0/24 probed from two vantage points, 200.0.1.0/24 once
+	db.Save(types.Tasks{{IP: 3355443201, Ping: true, RTT: 10 * time.Millisecond}, {IP: 3355443202}})
+	db.Save(types.Tasks{{IP: 3355443201, Vantage: "ams", Ping: true, RTT: 20 * time.Millisecond}, {IP: 3355443457, Ping: true, RTT: 30 * time.Millisecond}})
+
+	rollups, err := db.GetRollups(Rollup24, 3355443200, 3355508735)
+	if err != nil || len(rollups) != 2 {
+		t.Fatalf("FAILED: /24 rollups %+v, %v", rollups, err)
+	}
+	if r := rollups[0]; r.Start != 3355443200 || r.Probed != 2 || r.Responsive != 1 || r.RTT != 15*time.Millisecond {
+		t.Errorf("FAILED: wrong rollup of 200.0.0.0/24: %+v", r)
+	}
+	rollups, err = db.GetRollups(Rollup16, 3355443200, 3355443200)
+	if err != nil || len(rollups) != 1 {
+		t.Fatalf("FAILED: /16 rollups %+v, %v", rollups, err)
+	}
+	if r := rollups[0]; r.Probed != 3 || r.Responsive != 2 || r.RTT != 20*time.Millisecond {
+		t.Errorf("FAILED: wrong rollup of 200.0.0.0/16: %+v", r)
+	}
+	db.DropTable()
+}
+
+func TestPartitionIntegrational(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	for _, inet := range []bool{false, true} {
+		db := Postgres{
+			DBAddr:     "127.0.0.1",
+			DBPort:     "5432",
+			DBName:     "postgres",
+			DBTable:    fmt.Sprintf("testdb_%d", rand.Intn(math.MaxInt16)),
+			DBUsername: "postgres",
+			DBPassword: "123456",
+			UnitPrefix: 16,
+			InetIP:     inet,
+		}
+		db.Open()
+		db.Ping()
+
+		// results saved before partitioning are moved to partitions
+		if err := db.CreateTable(); err != nil {
+			t.Fatalf("FAILED: create table: %v", err)
+		}
+		db.Save(types.Tasks{{IP: 1, Ping: true}})
+		db.PartitionPrefix = 8
+		if err := db.CreateTable(); err != nil {
+			t.Fatalf("FAILED: partitioning (inet %v): %v", inet, err)
+		}
+		if err := db.Save(types.Tasks{{IP: 2147483647}, {IP: 2147483648}, {IP: 3355443201}, {IP: 4294967295}}); err != nil {
+			t.Fatalf("FAILED: save (inet %v): %v", inet, err)
+		}
+
+		var partitions, rows int
+		stmt := "SELECT count(*) FROM pg_inherits WHERE inhparent = $1::regclass;"
+		if err := db.c.QueryRow(stmt, db.DBTable).Scan(&partitions); partitions != 256 || err != nil {
+			t.Errorf("FAILED: %d partitions (inet %v), %v", partitions, inet, err)
+		}
+		if err := db.c.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s_p0;", db.DBTable)).Scan(&rows); rows != 1 || err != nil {
+			t.Errorf("FAILED: %d results moved to partition of 0.0.0.0/8 (inet %v), %v", rows, inet, err)
+		}
+		if err := db.c.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s_p200;", db.DBTable)).Scan(&rows); rows != 1 || err != nil {
+			t.Errorf("FAILED: %d results in partition of 200.0.0.0/8 (inet %v), %v", rows, inet, err)
+		}
+		if maxIP, err := db.GetMaxIP(); err != nil || inet && maxIP != 4294967295 {
+			t.Errorf("FAILED: max IP %d (inet %v), %v", maxIP, inet, err)
+		}
+		db.DropTable()
+		db.Close()
+	}
+}
+
+func TestReadResultsIntegrational(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	db := Postgres{
+		DBAddr:     "127.0.0.1",
+		DBPort:     "5432",
+		DBName:     "postgres",
+		DBTable:    fmt.Sprintf("testdb_%d", rand.Intn(math.MaxInt16)),
+		DBUsername: "postgres",
+		DBPassword: "123456",
+		UnitPrefix: 16,
+	}
+	db.Open()
+	db.Ping()
+	defer db.Close()
+	if err := db.CreateTable(); err != nil {
+		t.Fatalf("FAILED: create table: %v", err)
+	}
+	defer db.DropTable()
+
+	// addresses on both sides of 128.0.0.0 are read in unsigned order
+	results := types.Tasks{
+		{IP: 1<<32 - 1, Ping: true, RTT: 2 * time.Millisecond},
+		{IP: 1 << 31},
+		{IP: 1<<31 - 1, Ping: true},
+		{IP: 1},
+		{IP: 2, Vantage: "eu"},
+	}
+	if err := db.Save(results); err != nil {
+		t.Fatalf("FAILED: save: %v", err)
+	}
+	var ips []uint32
+	err := db.ReadResults("", 1, 1<<32-1, func(r types.Task) error {
+		ips = append(ips, r.IP)
+		if r.IP == 1<<32-1 && (!r.Ping || r.RTT != 2*time.Millisecond || r.Time.IsZero()) {
+			t.Errorf("FAILED: read %+v", r)
+		}
+		return nil
+	})
+	if expected := []uint32{1, 1<<31 - 1, 1 << 31, 1<<32 - 1}; err != nil || !reflect.DeepEqual(ips, expected) {
+		t.Errorf("FAILED: read %v, %v (actual) != %v (expected)", ips, err, expected)
+	}
+}
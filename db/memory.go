@@ -0,0 +1,63 @@
+package db
+
+import (
+	"sync"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+// Memory tracks work units in memory and discards results, for scans which write
+// results to file sinks only. Units are handed out in order from 0.0.0.0,
+// progress is lost on restart. Work unit is of UnitPrefix length (DefaultUnitPrefix if 0).
+type Memory struct {
+	UnitPrefix int
+
+	mtx   sync.Mutex
+	next  uint32
+	maxIP uint32
+}
+
+// Open does nothing
+func (db *Memory) Open() error { return nil }
+
+// Ping does nothing
+func (db *Memory) Ping() error { return nil }
+
+// CreateTable does nothing
+func (db *Memory) CreateTable() error { return nil }
+
+// Close does nothing
+func (db *Memory) Close() error { return nil }
+
+// UnitSize returns amount of addresses in work unit
+func (db *Memory) UnitSize() uint32 {
+	return unitSize(db.UnitPrefix)
+}
+
+// GetMaxIP returns maximum IP saved since start
+func (db *Memory) GetMaxIP() (uint32, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	return db.maxIP, nil
+}
+
+// GetOldestIP returns first IP of next work unit, wraps around the end of address space
+func (db *Memory) GetOldestIP() (uint32, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	ip := db.next
+	db.next += db.UnitSize()
+	return ip, nil
+}
+
+// Save discards results
+func (db *Memory) Save(results types.Tasks) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	for _, r := range results {
+		if r.IP > db.maxIP {
+			db.maxIP = r.IP
+		}
+	}
+	return nil
+}
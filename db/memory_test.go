@@ -0,0 +1,21 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func TestMemory(t *testing.T) {
+	db := &Memory{UnitPrefix: 1}
+	for _, exp := range []uint32{0, 1 << 31, 0} {
+		if ip, err := db.GetOldestIP(); err != nil || ip != exp {
+			t.Errorf("FAILED: oldest IP %d (expected %d), %v", ip, exp, err)
+		}
+	}
+
+	db.Save(types.Tasks{{IP: 5}, {IP: 42}, {IP: 7}})
+	if ip, err := db.GetMaxIP(); err != nil || ip != 42 {
+		t.Errorf("FAILED: max IP %d, %v", ip, err)
+	}
+}
@@ -0,0 +1,214 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrator implements versioned schema migrations.
+// It is optional for DB implementations.
+type Migrator interface {
+	// Migrate applies migrations which were not applied yet
+	Migrate() error
+	// Migrations returns known and applied migrations ordered by version
+	Migrations() ([]Migration, error)
+}
+
+// Migration is versioned change of schema
+type Migration struct {
+	Version  int
+	Name     string
+	Option   string    // migration is applied only if option is enabled, e.g. "inet"
+	Applied  time.Time // zero if not applied
+	Unknown  bool      // applied, but not known to this build (by newer version)
+	Disabled bool      // not applied as its option is not enabled
+
+	sql string
+}
+
+// migrationsFS contains migrations named VERSION_NAME.sql, placeholders like "{table}" in them are replaced.
+// Migrations written before versioning are idempotent, so databases created by older versions are migrated too.
+// Optional migration starts with "-- option: NAME" line.
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// Placeholders replaced in migrations
+const (
+	tablePlaceholder           = "{table}"            // table name
+	partitionPrefixPlaceholder = "{partition_prefix}" // prefix length of partitions
+)
+
+// optionPrefix starts first line of optional migration
+const optionPrefix = "-- option: "
+
+// Options of Postgres migrations
+const (
+	OptionInet      = "inet"      // addresses of results are stored as inet
+	OptionPartition = "partition" // results table is partitioned by address
+)
+
+// loadMigrations returns embedded migrations ordered by version
+func loadMigrations() (migrations []Migration, err error) {
+	files, err := migrationsFS.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), ".sql")
+		i := strings.Index(name, "_")
+		if i < 0 {
+			return nil, fmt.Errorf("migration %s is not named VERSION_NAME.sql", f.Name())
+		}
+		version, err := strconv.Atoi(name[:i])
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("wrong version of migration %s", f.Name())
+		}
+		b, err := migrationsFS.ReadFile(path.Join("migrations", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := Migration{Version: version, Name: name[i+1:], sql: string(b)}
+		if strings.HasPrefix(m.sql, optionPrefix) {
+			m.Option = strings.TrimSpace(strings.SplitN(m.sql, "\n", 2)[0][len(optionPrefix):])
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// migrations returns embedded migrations, optional ones are disabled unless their option is enabled
+func (db *Postgres) migrations() (migrations []Migration, err error) {
+	if migrations, err = loadMigrations(); err != nil {
+		return nil, err
+	}
+	for i, m := range migrations {
+		switch m.Option {
+		case "":
+		case OptionInet:
+			migrations[i].Disabled = !db.InetIP
+		case OptionPartition:
+			migrations[i].Disabled = db.PartitionPrefix == 0
+		default:
+			return nil, fmt.Errorf("unknown option %q of migration %d_%s", m.Option, m.Version, m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrate applies pending migrations, every one in its own transaction.
+// Session advisory lock is held meanwhile, so workers started at once do not migrate concurrently.
+func (db *Postgres) Migrate() (err error) {
+	migrations, err := db.migrations()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := db.c.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	lock := db.DBTable + "_schema_version"
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1));`, lock); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext($1));`, lock)
+
+	_, err = conn.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s_schema_version (version int PRIMARY KEY, name text, applied timestamptz);`, db.DBTable))
+	if err != nil {
+		return err
+	}
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(`SELECT version FROM %s_schema_version;`, db.DBTable))
+	if err != nil {
+		return err
+	}
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	placeholders := strings.NewReplacer(tablePlaceholder, db.DBTable, partitionPrefixPlaceholder, strconv.Itoa(db.PartitionPrefix))
+	for _, m := range migrations {
+		if applied[m.Version] || m.Disabled {
+			continue
+		}
+		txn, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err = txn.Exec(placeholders.Replace(m.sql)); err != nil {
+			txn.Rollback()
+			return fmt.Errorf("migration %d_%s: %v", m.Version, m.Name, err)
+		}
+		stmt := fmt.Sprintf(`INSERT INTO %s_schema_version (version, name, applied) VALUES ($1, $2, CURRENT_TIMESTAMP);`, db.DBTable)
+		if _, err = txn.Exec(stmt, m.Version, m.Name); err != nil {
+			txn.Rollback()
+			return err
+		}
+		if err = txn.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Migrations returns embedded migrations with time they were applied,
+// followed by applied migrations unknown to this build
+func (db *Postgres) Migrations() (migrations []Migration, err error) {
+	migrations, err = db.migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err = db.c.QueryRow(`SELECT to_regclass($1) IS NOT NULL;`, db.DBTable+"_schema_version").Scan(&exists); err != nil || !exists {
+		return migrations, err
+	}
+	rows, err := db.c.Query(fmt.Sprintf(`SELECT version, name, applied FROM %s_schema_version ORDER BY version;`, db.DBTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	known := map[int]int{}
+	for i, m := range migrations {
+		known[m.Version] = i
+	}
+	for rows.Next() {
+		var m Migration
+		if err = rows.Scan(&m.Version, &m.Name, &m.Applied); err != nil {
+			return nil, err
+		}
+		if i, ok := known[m.Version]; ok {
+			migrations[i].Applied, migrations[i].Disabled = m.Applied, false
+		} else {
+			m.Unknown = true
+			migrations = append(migrations, m)
+		}
+	}
+	return migrations, rows.Err()
+}
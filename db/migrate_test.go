@@ -0,0 +1,82 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("FAILED: %v", err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("FAILED: migration %s has version %d instead of %d", m.Name, m.Version, i+1)
+		}
+		if m.Name == "" || !strings.Contains(m.sql, tablePlaceholder) {
+			t.Errorf("FAILED: migration %d should have name and refer to %s", m.Version, tablePlaceholder)
+		}
+	}
+
+	// optional migrations are disabled unless option is set
+	for _, enabled := range []bool{false, true} {
+		db := &Postgres{InetIP: enabled}
+		if enabled {
+			db.PartitionPrefix = 8
+		}
+		if migrations, err = db.migrations(); err != nil {
+			t.Fatalf("FAILED: %v", err)
+		}
+		for _, m := range migrations {
+			if m.Disabled != (m.Option != "" && !enabled) {
+				t.Errorf("FAILED: migration %d_%s (option %q) disabled %v with options enabled %v", m.Version, m.Name, m.Option, m.Disabled, enabled)
+			}
+		}
+	}
+}
+
+func TestMigrateIntegrational(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	db := Postgres{
+		DBAddr:     "127.0.0.1",
+		DBPort:     "5432",
+		DBName:     "postgres",
+		DBTable:    fmt.Sprintf("testdb_%d", rand.Intn(math.MaxInt16)),
+		DBUsername: "postgres",
+		DBPassword: "123456",
+	}
+	db.Open()
+	db.Ping()
+	defer db.Close()
+
+	migrations, err := db.Migrations()
+	if err != nil || len(migrations) == 0 || !migrations[0].Applied.IsZero() {
+		t.Fatalf("FAILED: migrations of new database should be pending: %+v, %v", migrations, err)
+	}
+
+	// table created before versioning is migrated by idempotent migrations
+	if _, err = db.c.Exec(fmt.Sprintf(`CREATE TABLE %s (ip int PRIMARY KEY, ping bool, timestamp timestamp);`, db.DBTable)); err != nil {
+		t.Fatalf("FAILED: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = db.Migrate(); err != nil {
+			t.Fatalf("FAILED: migrate %d: %v", i, err)
+		}
+	}
+	if migrations, err = db.Migrations(); err != nil {
+		t.Fatalf("FAILED: %v", err)
+	}
+	for _, m := range migrations {
+		if m.Applied.IsZero() || m.Unknown {
+			t.Errorf("FAILED: migration %d_%s should be applied", m.Version, m.Name)
+		}
+	}
+	db.DropTable()
+}
@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS {table} (ip int PRIMARY KEY, ping bool, timestamp timestamp);
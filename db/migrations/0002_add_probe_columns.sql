@@ -0,0 +1,10 @@
+-- columns were added to tables created before migrations
+ALTER TABLE {table}
+	ADD COLUMN IF NOT EXISTS probe text,
+	ADD COLUMN IF NOT EXISTS icmp_type smallint,
+	ADD COLUMN IF NOT EXISTS icmp_code smallint,
+	ADD COLUMN IF NOT EXISTS router int,
+	ADD COLUMN IF NOT EXISTS replies smallint,
+	ADD COLUMN IF NOT EXISTS anomalies smallint,
+	ADD COLUMN IF NOT EXISTS reply_from int,
+	ADD COLUMN IF NOT EXISTS vantage text NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+-- results are stored per vantage point, primary key was (ip) before
+DO $$ BEGIN
+	IF NOT EXISTS (SELECT 1 FROM information_schema.key_column_usage
+		WHERE table_name = '{table}' AND constraint_name = '{table}_pkey' AND column_name = 'vantage') THEN
+		ALTER TABLE {table} DROP CONSTRAINT {table}_pkey, ADD PRIMARY KEY (ip, vantage);
+	END IF;
+END $$;
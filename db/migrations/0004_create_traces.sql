@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS {table}_traces (target int, ttl smallint, method text, router int, rtt real, timestamp timestamp, PRIMARY KEY (target, ttl));
@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS {table}_ptr (ip int PRIMARY KEY, hostname text, timestamp timestamp);
@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS {table}_rescans (id bigserial PRIMARY KEY, start int, prefix smallint, priority int,
+	deadline timestamptz, created timestamptz, taken timestamptz, done timestamptz);
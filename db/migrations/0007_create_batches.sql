@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS {table}_batches (worker text, started timestamptz, seq bigint, size int, timestamp timestamptz,
+	PRIMARY KEY (worker, started, seq));
@@ -0,0 +1,3 @@
+-- time of last save per work unit, units of every size are filled in on start
+CREATE TABLE IF NOT EXISTS {table}_ranges (start int, size bigint, timestamp timestamp, PRIMARY KEY (size, start));
+CREATE INDEX IF NOT EXISTS {table}_ranges_timestamp ON {table}_ranges (size, timestamp NULLS FIRST, start);
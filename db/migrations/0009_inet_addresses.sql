@@ -0,0 +1,7 @@
+-- option: inet
+-- addresses of results are stored as inet instead of signed int (unsigned address reinterpreted)
+ALTER TABLE {table}
+	ALTER COLUMN ip TYPE inet USING '0.0.0.0'::inet + (ip::bigint & 4294967295),
+	ALTER COLUMN router TYPE inet USING '0.0.0.0'::inet + (router::bigint & 4294967295),
+	ALTER COLUMN reply_from TYPE inet USING '0.0.0.0'::inet + (reply_from::bigint & 4294967295);
+CREATE INDEX IF NOT EXISTS {table}_ip_gist ON {table} USING gist (ip inet_ops);
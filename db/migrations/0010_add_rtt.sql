@@ -0,0 +1,2 @@
+-- time to first reply in milliseconds, NULL if host did not respond
+ALTER TABLE {table} ADD COLUMN IF NOT EXISTS rtt real;
@@ -0,0 +1,4 @@
+-- aggregates of results per /24 and /16 maintained by Save, start is signed int as in other tables,
+-- rtt is mean of rtt_samples responsive results in milliseconds
+CREATE TABLE IF NOT EXISTS {table}_rollup24 (start int PRIMARY KEY, probed int, responsive int, rtt double precision, rtt_samples int, last_scanned timestamp);
+CREATE TABLE IF NOT EXISTS {table}_rollup16 (start int PRIMARY KEY, probed int, responsive int, rtt double precision, rtt_samples int, last_scanned timestamp);
@@ -0,0 +1,40 @@
+-- option: partition
+-- results are moved to table partitioned by address, one partition per /{partition_prefix} named by its number,
+-- e.g. {table}_p10 for 10.0.0.0/8. Bounds are signed int or inet, whatever addresses are stored as.
+DO $$
+DECLARE
+	step bigint := 1::bigint << (32 - {partition_prefix});
+	inet_ip bool;
+	lo bigint;
+	hi bigint;
+	bound_from text;
+	bound_to text;
+BEGIN
+	SELECT data_type = 'inet' INTO inet_ip FROM information_schema.columns WHERE table_name = '{table}' AND column_name = 'ip';
+
+	ALTER TABLE {table} RENAME TO {table}_unpartitioned;
+	ALTER INDEX {table}_pkey RENAME TO {table}_unpartitioned_pkey;
+	DROP INDEX IF EXISTS {table}_ip_gist;
+	CREATE TABLE {table} (LIKE {table}_unpartitioned INCLUDING DEFAULTS) PARTITION BY RANGE (ip);
+	ALTER TABLE {table} ADD PRIMARY KEY (ip, vantage);
+	IF inet_ip THEN
+		CREATE INDEX {table}_ip_gist ON {table} USING gist (ip inet_ops);
+	END IF;
+
+	FOR lo IN SELECT generate_series(0, 4294967295, step) LOOP
+		hi := lo + step;
+		IF inet_ip THEN
+			bound_from := quote_literal('0.0.0.0'::inet + lo);
+			bound_to := CASE WHEN hi = 4294967296 THEN 'MAXVALUE' ELSE quote_literal('0.0.0.0'::inet + hi) END;
+		ELSE
+			-- addresses from 128.0.0.0 are negative, so partition below it ends at MAXVALUE and the last one at 0
+			bound_from := CASE WHEN lo >= 2147483648 THEN lo - 4294967296 ELSE lo END;
+			bound_to := CASE WHEN hi = 2147483648 THEN 'MAXVALUE' WHEN hi > 2147483648 THEN (hi - 4294967296)::text ELSE hi::text END;
+		END IF;
+		EXECUTE format('CREATE TABLE %I PARTITION OF {table} FOR VALUES FROM (%s) TO (%s)',
+			'{table}_p' || (lo / step), bound_from, bound_to);
+	END LOOP;
+
+	INSERT INTO {table} SELECT * FROM {table}_unpartitioned;
+	DROP TABLE {table}_unpartitioned;
+END $$;
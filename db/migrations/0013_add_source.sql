@@ -0,0 +1,2 @@
+-- scanner which produced imported result, NULL for own results
+ALTER TABLE {table} ADD COLUMN IF NOT EXISTS source text;
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nanorobocop/worldping/pkg/types"
+	"github.com/nanorobocop/worldping/pkg/utils"
+)
+
+// Prefix lengths of rollups
+const (
+	Rollup24 = 24
+	Rollup16 = 16
+)
+
+// updateRollups recomputes aggregates of /24 prefixes containing ips from results, then of their /16 prefixes.
+// Prefixes are locked in order before, so transactions saving into the same prefix recompute it one after another
+// and the later one sees results committed by the earlier.
+func (db *Postgres) updateRollups(txn *sql.Tx, ips []uint32) (err error) {
+	var starts24, starts16 []int32
+	seen24, seen16 := make(map[uint32]bool), make(map[uint32]bool)
+	for _, ip := range ips {
+		if start := ip &^ 0xff; !seen24[start] {
+			seen24[start] = true
+			starts24 = append(starts24, *utils.UintToInt(start))
+		}
+		if start := ip &^ 0xffff; !seen16[start] {
+			seen16[start] = true
+			starts16 = append(starts16, *utils.UintToInt(start))
+		}
+	}
+	sort.Slice(starts24, func(i, j int) bool { return starts24[i] < starts24[j] })
+	sort.Slice(starts16, func(i, j int) bool { return starts16[i] < starts16[j] })
+
+	// locks are taken in order of sorted arrays, /24 ones before /16 ones, so transactions do not deadlock
+	stmt := `SELECT pg_advisory_xact_lock(hashtext($1), start) FROM unnest($2::int[]) AS start;`
+	if _, err = txn.Exec(stmt, db.DBTable+"_rollup24", pq.Array(starts24)); err != nil {
+		return err
+	}
+	if _, err = txn.Exec(stmt, db.DBTable+"_rollup16", pq.Array(starts16)); err != nil {
+		return err
+	}
+
+	stmt = fmt.Sprintf(`INSERT INTO %[1]s_rollup24 (start, probed, responsive, rtt, rtt_samples, last_scanned)
+		SELECT p.start, count(DISTINCT t.ip), count(DISTINCT t.ip) FILTER (WHERE t.ping), avg(t.rtt), count(t.rtt), max(t.timestamp)
+		FROM unnest($1::int[]) AS p (start) JOIN %[1]s t ON (t.ip BETWEEN %[2]s AND %[3]s)
+		GROUP BY p.start
+		ON CONFLICT (start) DO UPDATE SET probed = excluded.probed, responsive = excluded.responsive,
+			rtt = excluded.rtt, rtt_samples = excluded.rtt_samples, last_scanned = excluded.last_scanned;`,
+		db.DBTable, db.ipToColumn("p.start"), db.ipToColumn("(p.start + 255)"))
+	if _, err = txn.Exec(stmt, pq.Array(starts24)); err != nil {
+		return err
+	}
+
+	// /24 prefixes of /16 start from its start up to start + 255 * 256
+	stmt = fmt.Sprintf(`INSERT INTO %[1]s_rollup16 (start, probed, responsive, rtt, rtt_samples, last_scanned)
+		SELECT p.start, sum(r.probed), sum(r.responsive), sum(r.rtt * r.rtt_samples) / NULLIF(sum(r.rtt_samples), 0),
+			sum(r.rtt_samples), max(r.last_scanned)
+		FROM unnest($1::int[]) AS p (start) JOIN %[1]s_rollup24 r ON (r.start BETWEEN p.start AND p.start + 65280)
+		GROUP BY p.start
+		ON CONFLICT (start) DO UPDATE SET probed = excluded.probed, responsive = excluded.responsive,
+			rtt = excluded.rtt, rtt_samples = excluded.rtt_samples, last_scanned = excluded.last_scanned;`, db.DBTable)
+	_, err = txn.Exec(stmt, pq.Array(starts16))
+	return err
+}
+
+// RebuildRollups recomputes aggregates of all prefixes from results, one /16 per transaction.
+// It is needed for results saved before rollups were introduced only.
+func (db *Postgres) RebuildRollups() (err error) {
+	ips := make([]uint32, 256)
+	for start16 := uint64(0); start16 < 1<<32; start16 += 1 << 16 {
+		for i := range ips {
+			ips[i] = uint32(start16) + uint32(i)<<8
+		}
+		txn, err := db.c.Begin()
+		if err != nil {
+			return err
+		}
+		if err = db.updateRollups(txn, ips); err != nil {
+			txn.Rollback()
+			return err
+		}
+		if err = txn.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRollups returns aggregates of prefixes of length prefix (Rollup24 or Rollup16)
+// starting in range [start, end], ordered by start
+func (db *Postgres) GetRollups(prefix int, start, end uint32) (rollups []types.Rollup, err error) {
+	if prefix != Rollup24 && prefix != Rollup16 {
+		return nil, fmt.Errorf("rollups are kept for /%d and /%d only", Rollup24, Rollup16)
+	}
+	// range crossing 128.0.0.0 wraps around in signed representation
+	where := "start BETWEEN $1 AND $2"
+	if *utils.UintToInt(start) > *utils.UintToInt(end) {
+		where = "(start >= $1 OR start <= $2)"
+	}
+	stmt := fmt.Sprintf(`SELECT start, probed, responsive, rtt, last_scanned FROM %s_rollup%d WHERE %s
+		ORDER BY start::bigint & 4294967295;`, db.DBTable, prefix, where)
+	rows, err := db.c.Query(stmt, utils.UintToInt(start), utils.UintToInt(end))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var signed int32
+		var rtt sql.NullFloat64
+		var lastScanned pq.NullTime
+		r := types.Rollup{Prefix: prefix}
+		if err = rows.Scan(&signed, &r.Probed, &r.Responsive, &rtt, &lastScanned); err != nil {
+			return nil, err
+		}
+		r.Start = *utils.IntToUint(signed)
+		r.RTT = time.Duration(rtt.Float64 * float64(time.Millisecond))
+		r.LastScanned = lastScanned.Time
+		rollups = append(rollups, r)
+	}
+	return rollups, rows.Err()
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+
+	_ "modernc.org/sqlite" // pure Go driver, builds without cgo
+)
+
+// sqliteTimeFormat is format of CURRENT_TIMESTAMP in SQLite
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// SQLite contains connection to embedded database file, for trying scanner out and small scans.
+// Tables follow Postgres ones, but addresses are stored as unsigned integers.
+// Scan progress is tracked per work unit of UnitPrefix length (DefaultUnitPrefix if 0).
+type SQLite struct {
+	c               *sql.DB
+	DBPath, DBTable string
+	UnitPrefix      int
+}
+
+// Open opens database file, it is created if not exists
+func (db *SQLite) Open() (err error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)", db.DBPath)
+	if db.c, err = sql.Open("sqlite", dsn); err != nil {
+		return err
+	}
+	// SQLite allows single writer, concurrent savers wait for connection instead of failing with "database is locked"
+	db.c.SetMaxOpenConns(1)
+	return nil
+}
+
+// Ping checks connection to db
+func (db *SQLite) Ping() error {
+	return db.c.Ping()
+}
+
+// CreateTable creates tables if not exist
+func (db *SQLite) CreateTable() (err error) {
+	_, err = db.c.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (ip integer, vantage text NOT NULL DEFAULT '', ping bool, probe text,
+			icmp_type integer, icmp_code integer, router integer, replies integer, anomalies integer, reply_from integer, rtt real,
+			source text, timestamp timestamp, PRIMARY KEY (ip, vantage));
+		CREATE TABLE IF NOT EXISTS %s_batches (worker text, started integer, seq integer, size integer, timestamp timestamp,
+			PRIMARY KEY (worker, started, seq));
+		CREATE TABLE IF NOT EXISTS %s_ranges (start integer, size integer, timestamp timestamp, PRIMARY KEY (size, start));
+		CREATE INDEX IF NOT EXISTS %s_ranges_timestamp ON %s_ranges (size, timestamp, start);`,
+		db.DBTable, db.DBTable, db.DBTable, db.DBTable, db.DBTable))
+	if err != nil {
+		return err
+	}
+	// units of configured size, unless they exist already (see Postgres.fillRanges)
+	stmt := fmt.Sprintf(`INSERT INTO %s_ranges (start, size, timestamp)
+		WITH RECURSIVE r (start) AS (SELECT 0 UNION ALL SELECT start + ?1 FROM r WHERE start + ?1 < %d)
+		SELECT start, ?1, (SELECT max(timestamp) FROM %s WHERE ip = r.start) FROM r
+		WHERE NOT EXISTS (SELECT 1 FROM %s_ranges WHERE size = ?1);`, db.DBTable, uint64(1)<<32, db.DBTable, db.DBTable)
+	_, err = db.c.Exec(stmt, int64(db.UnitSize()))
+	return err
+}
+
+// DropTable drops tables (for tests)
+func (db *SQLite) DropTable() (err error) {
+	_, err = db.c.Exec(fmt.Sprintf(`DROP TABLE %s; DROP TABLE %s_batches; DROP TABLE %s_ranges;`, db.DBTable, db.DBTable, db.DBTable))
+	return err
+}
+
+// UnitSize returns amount of addresses in work unit
+func (db *SQLite) UnitSize() uint32 {
+	return unitSize(db.UnitPrefix)
+}
+
+// GetMaxIP return maximum IP in db
+func (db *SQLite) GetMaxIP() (maxIP uint32, err error) {
+	err = db.c.QueryRow(fmt.Sprintf("SELECT MAX(ip) FROM %s;", db.DBTable)).Scan(&maxIP)
+	return maxIP, err
+}
+
+// GetOldestIP returns first IP of work unit saved longest time ago (or never, NULLs go first)
+func (db *SQLite) GetOldestIP() (oldestIP uint32, err error) {
+	stmt := fmt.Sprintf("SELECT start FROM %s_ranges WHERE size = ?1 ORDER BY timestamp, start LIMIT 1;", db.DBTable)
+	err = db.c.QueryRow(stmt, int64(db.UnitSize())).Scan(&oldestIP)
+	return oldestIP, err
+}
+
+// Release marks work unit containing next as never saved (see Postgres.Release)
+func (db *SQLite) Release(next uint32) (err error) {
+	start := next &^ (db.UnitSize() - 1)
+	stmt := fmt.Sprintf("UPDATE %s_ranges SET timestamp = NULL WHERE size = ?1 AND start = ?2;", db.DBTable)
+	_, err = db.c.Exec(stmt, int64(db.UnitSize()), int64(start))
+	return err
+}
+
+// Save commits information to db, one row per IP and vantage point (see Postgres.Save).
+// Rows are inserted one by one with prepared statement in single transaction.
+func (db *SQLite) Save(results types.Tasks) (err error) {
+	txn, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	if err = db.save(txn, results); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// SaveBatch saves results as Save does, batch ID is recorded in <table>_batches
+func (db *SQLite) SaveBatch(batch types.Batch) (duplicate bool, err error) {
+	txn, err := db.c.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer txn.Rollback()
+
+	stmt := fmt.Sprintf(`INSERT INTO %s_batches (worker, started, seq, size, timestamp) VALUES (?1, ?2, ?3, ?4, CURRENT_TIMESTAMP)
+		ON CONFLICT DO NOTHING;`, db.DBTable)
+	res, err := txn.Exec(stmt, batch.ID.Worker, batch.ID.Started.UnixNano(), int64(batch.ID.Seq), len(batch.Results))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return true, nil // committed by previous attempt
+	}
+
+	if err = db.save(txn, batch.Results); err != nil {
+		return false, err
+	}
+	return false, txn.Commit()
+}
+
+// save upserts results and marks their work units in transaction.
+// Times are stored as CURRENT_TIMESTAMP does (UTC text), so they are ordered as strings.
+func (db *SQLite) save(txn *sql.Tx, results types.Tasks) (err error) {
+	stmt, err := txn.Prepare(fmt.Sprintf(`INSERT INTO %s AS t (ip, vantage, ping, probe, icmp_type, icmp_code, router, replies, anomalies, reply_from, rtt, source, timestamp)
+		VALUES (?1, ?2, ?3, NULLIF(?4, ''), NULLIF(?5, 0),
+			CASE WHEN ?5 = 0 THEN NULL ELSE ?6 END,
+			CASE WHEN ?5 = 0 THEN NULL ELSE ?7 END,
+			?8, ?9, NULLIF(?10, 0), NULLIF(?12, 0), NULLIF(?13, ''), COALESCE(?11, CURRENT_TIMESTAMP))
+		ON CONFLICT (ip, vantage) DO UPDATE SET ping = excluded.ping, probe = excluded.probe,
+			icmp_type = excluded.icmp_type, icmp_code = excluded.icmp_code, router = excluded.router,
+			replies = excluded.replies, anomalies = excluded.anomalies, reply_from = excluded.reply_from,
+			rtt = excluded.rtt, source = excluded.source, timestamp = excluded.timestamp
+		WHERE t.timestamp IS NULL OR t.timestamp <= excluded.timestamp`, db.DBTable))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	unitTimes := make(map[uint32]interface{}) // latest time of results in unit, nil - now
+	mask := ^(db.UnitSize() - 1)
+	for _, r := range results {
+		var t interface{}
+		if !r.Time.IsZero() {
+			t = r.Time.UTC().Format(sqliteTimeFormat)
+		}
+		unit := r.IP & mask
+		if prev, ok := unitTimes[unit]; !ok || prev != nil && (t == nil || t.(string) > prev.(string)) {
+			unitTimes[unit] = t
+		}
+		_, err = stmt.Exec(int64(r.IP), r.Vantage, r.Ping, r.Probe, int64(r.ICMPType), int64(r.ICMPCode), int64(r.Router),
+			int64(r.Replies), int64(r.Anomalies), int64(r.ReplyFrom), t, float64(r.RTT)/float64(time.Millisecond), r.Source)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt, err = txn.Prepare(fmt.Sprintf(`INSERT INTO %s_ranges AS r (start, size, timestamp) VALUES (?1, ?2, COALESCE(?3, CURRENT_TIMESTAMP))
+		ON CONFLICT (size, start) DO UPDATE SET timestamp = MAX(COALESCE(r.timestamp, ''), excluded.timestamp)`, db.DBTable))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for unit, t := range unitTimes {
+		if _, err = stmt.Exec(int64(unit), int64(db.UnitSize()), t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadResults passes results of vantage point with addresses in range [start, end] to f in ascending order
+func (db *SQLite) ReadResults(vantage string, start, end uint32, f func(types.Task) error) (err error) {
+	rows, err := db.c.Query(fmt.Sprintf(`SELECT ip, ping, COALESCE(probe, ''), rtt, COALESCE(source, ''), timestamp FROM %s
+		WHERE vantage = ?1 AND ip BETWEEN ?2 AND ?3 ORDER BY ip;`, db.DBTable), vantage, int64(start), int64(end))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ip int64
+		var rtt sql.NullFloat64
+		var timestamp sql.NullTime
+		t := types.Task{Vantage: vantage}
+		if err = rows.Scan(&ip, &t.Ping, &t.Probe, &rtt, &t.Source, &timestamp); err != nil {
+			return err
+		}
+		t.IP = uint32(ip)
+		t.RTT = time.Duration(rtt.Float64 * float64(time.Millisecond))
+		t.Time = timestamp.Time
+		if err = f(t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Close closes connection to DB
+func (db *SQLite) Close() error {
+	return db.c.Close()
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func openSQLite(t *testing.T, prefix int) *SQLite {
+	db := &SQLite{DBPath: filepath.Join(t.TempDir(), "worldping.db"), DBTable: "worldping", UnitPrefix: prefix}
+	if err := db.Open(); err != nil {
+		t.Fatalf("Cannot open: %v", err)
+	}
+	if err := db.CreateTable(); err != nil {
+		t.Fatalf("Cannot create table: %v", err)
+	}
+	return db
+}
+
+func TestSQLiteSave(t *testing.T) {
+	db := openSQLite(t, 8)
+	defer db.Close()
+
+	results := types.Tasks{
+		{IP: 1 << 24, Ping: true, Probe: "echo", Replies: 1},
+		{IP: 1<<24 + 1, ICMPType: 3, ICMPCode: 1, Router: 42},
+		{IP: 1 << 24, Vantage: "eu"},
+		{IP: 1<<32 - 1},
+	}
+	if err := db.Save(results); err != nil {
+		t.Fatalf("FAILED: save: %v", err)
+	}
+	// upsert replaces previous result of the same vantage point
+	if err := db.Save(types.Tasks{{IP: 1 << 24}}); err != nil {
+		t.Fatalf("FAILED: save: %v", err)
+	}
+
+	var rows int
+	var probe, router interface{}
+	db.c.QueryRow("SELECT count(*) FROM worldping;").Scan(&rows)
+	db.c.QueryRow("SELECT probe, router FROM worldping WHERE ip = 16777216 AND vantage = '';").Scan(&probe, &router)
+	if rows != 4 || probe != nil || router != nil {
+		t.Errorf("FAILED: %d rows, probe %v, router %v", rows, probe, router)
+	}
+	db.c.QueryRow("SELECT router FROM worldping WHERE ip = 16777217;").Scan(&router)
+	if router != int64(42) {
+		t.Errorf("FAILED: router %v instead of 42", router)
+	}
+
+	if ip, err := db.GetMaxIP(); err != nil || ip != 1<<32-1 {
+		t.Errorf("FAILED: max IP %d, %v", ip, err)
+	}
+}
+
+func TestSQLiteGetOldest(t *testing.T) {
+	db := openSQLite(t, 16)
+	defer db.Close()
+
+	var units int
+	db.c.QueryRow("SELECT count(*) FROM worldping_ranges WHERE size = 65536;").Scan(&units)
+	if units != 1<<16 {
+		t.Fatalf("FAILED: %d units instead of %d", units, 1<<16)
+	}
+
+	// saved units go to the end of the queue
+	for _, exp := range []uint32{0, 1 << 16, 2 << 16} {
+		ip, err := db.GetOldestIP()
+		if err != nil || ip != exp {
+			t.Errorf("FAILED: oldest IP %d (expected %d), %v", ip, exp, err)
+		}
+		db.Save(types.Tasks{{IP: ip + 5}})
+	}
+
+	// units are not refilled when table is created again
+	if err := db.CreateTable(); err != nil {
+		t.Fatalf("FAILED: create table again: %v", err)
+	}
+	if ip, err := db.GetOldestIP(); err != nil || ip != 3<<16 {
+		t.Errorf("FAILED: oldest IP %d after restart, %v", ip, err)
+	}
+
+	// unit interrupted by drain is handed out again
+	if err := db.Release(1<<16 + 6); err != nil {
+		t.Fatalf("FAILED: release: %v", err)
+	}
+	if ip, err := db.GetOldestIP(); err != nil || ip != 1<<16 {
+		t.Errorf("FAILED: oldest IP %d after release, %v", ip, err)
+	}
+}
+
+func TestSQLiteSaveBatch(t *testing.T) {
+	db := openSQLite(t, 8)
+	defer db.Close()
+
+	batch := types.Batch{
+		ID:      types.BatchID{Worker: "worker-1", Started: time.Now(), Seq: 1},
+		Results: types.Tasks{{IP: 1, Ping: true}},
+	}
+	if dup, err := db.SaveBatch(batch); dup || err != nil {
+		t.Errorf("FAILED: save: %v, %v", dup, err)
+	}
+
+	// retry is skipped, results of retry are not saved
+	batch.Results = types.Tasks{{IP: 1}}
+	if dup, err := db.SaveBatch(batch); !dup || err != nil {
+		t.Errorf("FAILED: retry should be duplicate: %v, %v", dup, err)
+	}
+	var ping bool
+	if err := db.c.QueryRow("SELECT ping FROM worldping WHERE ip = 1;").Scan(&ping); err != nil || !ping {
+		t.Errorf("FAILED: retry should not overwrite results: %v, %v", ping, err)
+	}
+
+	batch.ID.Seq = 2
+	if dup, err := db.SaveBatch(batch); dup || err != nil {
+		t.Errorf("FAILED: next batch: %v, %v", dup, err)
+	}
+}
+
+func TestSQLiteSaveHistory(t *testing.T) {
+	db := openSQLite(t, 16)
+	defer db.Close()
+
+	// imported result keeps its time and does not replace newer one
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := db.Save(types.Tasks{{IP: 1, Ping: true}, {IP: 2, Ping: true, Time: old}}); err != nil {
+		t.Fatalf("FAILED: save: %v", err)
+	}
+	if err := db.Save(types.Tasks{{IP: 1, Time: old}, {IP: 2, Time: old.Add(time.Hour)}}); err != nil {
+		t.Fatalf("FAILED: save: %v", err)
+	}
+
+	var ping1, ping2 bool
+	var ts2, unit time.Time
+	db.c.QueryRow("SELECT ping FROM worldping WHERE ip = 1;").Scan(&ping1)
+	db.c.QueryRow("SELECT ping, timestamp FROM worldping WHERE ip = 2;").Scan(&ping2, &ts2)
+	if !ping1 || ping2 || !ts2.Equal(old.Add(time.Hour)) {
+		t.Errorf("FAILED: ping of 1 %v, ping of 2 %v at %s", ping1, ping2, ts2)
+	}
+	// unit was saved now, older results do not move it back
+	db.c.QueryRow("SELECT timestamp FROM worldping_ranges WHERE start = 0 AND size = 65536;").Scan(&unit)
+	if time.Since(unit) > time.Minute {
+		t.Errorf("FAILED: unit saved at %s", unit)
+	}
+}
+
+func TestSQLiteReadResults(t *testing.T) {
+	db := openSQLite(t, 16)
+	defer db.Close()
+
+	results := types.Tasks{
+		{IP: 1<<32 - 1, Ping: true, Probe: "echo", RTT: 1500 * time.Microsecond},
+		{IP: 1 << 24, Source: "zmap"},
+		{IP: 1},
+		{IP: 2, Vantage: "eu"},
+	}
+	if err := db.Save(results); err != nil {
+		t.Fatalf("FAILED: save: %v", err)
+	}
+
+	var read types.Tasks
+	err := db.ReadResults("", 1<<24, 1<<32-1, func(r types.Task) error {
+		read = append(read, r)
+		return nil
+	})
+	if err != nil || len(read) != 2 || read[0].IP != 1<<24 || read[1].IP != 1<<32-1 {
+		t.Fatalf("FAILED: read %+v, %v", read, err)
+	}
+	if r := read[1]; !r.Ping || r.Probe != "echo" || r.RTT != 1500*time.Microsecond || r.Time.IsZero() {
+		t.Errorf("FAILED: read %+v", r)
+	}
+	if read[0].RTT != 0 || read[0].Source != "zmap" || read[1].Source != "" {
+		t.Errorf("FAILED: RTT %v or source %q of silent address", read[0].RTT, read[0].Source)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nanorobocop/worldping/pkg/snapshot"
+	"github.com/nanorobocop/worldping/pkg/utils"
+)
+
+// Output formats of diff
+const (
+	diffTable = "table"
+	diffJSONL = "jsonl"
+)
+
+// dbRound is prefix of round read from database, followed by vantage point
+const dbRound = "db:"
+
+// diffOptions are arguments of diff command
+type diffOptions struct {
+	start, end    uint32
+	per           int
+	format        string
+	before, after string // snapshot file or db:VANTAGE
+}
+
+// diff compares responsive addresses of two rounds:
+// "diff [-prefix CIDR] [-per N] [-format table|jsonl] BEFORE AFTER",
+// round is snapshot file or db:VANTAGE for current results of vantage point in database.
+func (env *envStruct) diff(args []string) {
+	opts, err := parseDiff(args)
+	if err != nil {
+		env.log.Fatalf("Wrong diff arguments: %v", err)
+	}
+	if strings.HasPrefix(opts.before, dbRound) || strings.HasPrefix(opts.after, dbRound) {
+		if err := env.dbConn.Open(); err != nil {
+			env.log.Fatalf("Cannot open connection to database: %+v", err)
+		}
+		defer env.dbConn.Close()
+		if err := env.dbConn.Ping(); err != nil {
+			env.log.Fatalf("Cannot ping DB: %v", err)
+		}
+	}
+	before, err := env.loadRound(opts.before, opts.start, opts.end)
+	if err != nil {
+		env.log.Fatalf("Cannot load %s: %v", opts.before, err)
+	}
+	after, err := env.loadRound(opts.after, opts.start, opts.end)
+	if err != nil {
+		env.log.Fatalf("Cannot load %s: %v", opts.after, err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	deltas, err := snapshot.Diff(before, after, opts.start, opts.end, opts.per, func(ip uint32, added bool) error {
+		if opts.format != diffJSONL {
+			return nil
+		}
+		return enc.Encode(changeRecord{IP: utils.IPToStr(ip), Change: changeName(added)})
+	})
+	if err != nil {
+		env.log.Fatalf("Cannot compare rounds: %v", err)
+	}
+	if opts.format == diffJSONL {
+		for _, d := range deltas {
+			if err = enc.Encode(newDeltaRecord(d)); err != nil {
+				env.log.Fatalf("Cannot write delta: %v", err)
+			}
+		}
+		return
+	}
+	printDeltas(os.Stdout, deltas)
+}
+
+// loadRound reads round from snapshot file or from database for db:VANTAGE
+func (env *envStruct) loadRound(name string, start, end uint32) (*snapshot.Snapshot, error) {
+	if strings.HasPrefix(name, dbRound) {
+		return readRound(env.dbConn, strings.TrimPrefix(name, dbRound), false, start, end)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return snapshot.Read(f)
+}
+
+// parseDiff parses "[-prefix CIDR] [-per N] [-format table|jsonl] BEFORE AFTER"
+func parseDiff(args []string) (opts diffOptions, err error) {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	prefix := fs.String("prefix", "0.0.0.0/0", "compare addresses of prefix only")
+	fs.IntVar(&opts.per, "per", 24, "length of prefixes deltas are counted for")
+	fs.StringVar(&opts.format, "format", diffTable, "output format: "+diffTable+" (deltas) or "+diffJSONL+" (addresses and deltas)")
+	if err = fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if opts.start, opts.end, err = parsePrefixRange(*prefix); err != nil {
+		return opts, err
+	}
+	if opts.per < 0 || opts.per > 32 {
+		return opts, fmt.Errorf("wrong prefix length %d", opts.per)
+	}
+	if opts.format != diffTable && opts.format != diffJSONL {
+		return opts, fmt.Errorf("unknown format %q (should be %s or %s)", opts.format, diffTable, diffJSONL)
+	}
+	if fs.NArg() != 2 {
+		return opts, fmt.Errorf("two rounds are expected")
+	}
+	opts.before, opts.after = fs.Arg(0), fs.Arg(1)
+	return opts, nil
+}
+
+// changeRecord is JSON line of address which became responsive or stopped responding
+type changeRecord struct {
+	IP     string `json:"ip"`
+	Change string `json:"change"`
+}
+
+// deltaRecord is JSON line of prefix delta
+type deltaRecord struct {
+	Prefix  string `json:"prefix"`
+	Before  int    `json:"before"`
+	After   int    `json:"after"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+}
+
+func newDeltaRecord(d snapshot.Delta) deltaRecord {
+	return deltaRecord{Prefix: fmt.Sprintf("%s/%d", utils.IPToStr(d.Start), d.Prefix), Before: d.Before, After: d.After,
+		Added: d.Added, Removed: d.Removed}
+}
+
+func changeName(added bool) string {
+	if added {
+		return "added"
+	}
+	return "removed"
+}
+
+// printDeltas writes table of prefix deltas with total of added and removed addresses
+func printDeltas(out io.Writer, deltas []snapshot.Delta) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PREFIX\tBEFORE\tAFTER\tADDED\tREMOVED")
+	added, removed := 0, 0
+	for _, d := range deltas {
+		r := newDeltaRecord(d)
+		fmt.Fprintf(w, "%s\t%d\t%d\t+%d\t-%d\n", r.Prefix, r.Before, r.After, r.Added, r.Removed)
+		added += r.Added
+		removed += r.Removed
+	}
+	fmt.Fprintf(w, "TOTAL\t-\t-\t+%d\t-%d\n", added, removed)
+	w.Flush()
+}
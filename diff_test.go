@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nanorobocop/worldping/pkg/snapshot"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func TestParseDiff(t *testing.T) {
+	opts, err := parseDiff([]string{"-prefix", "10.0.0.0/8", "-per", "16", "-format", "jsonl", "before.wps", "db:eu"})
+	expected := diffOptions{start: 167772160, end: 184549375, per: 16, format: diffJSONL, before: "before.wps", after: "db:eu"}
+	if err != nil || opts != expected {
+		t.Errorf("TestParseDiff FAILED: %+v, %v (actual) != %+v (expected)", opts, err, expected)
+	}
+
+	for _, args := range [][]string{{"before.wps"}, {"-format", "csv", "a", "b"}, {"-per", "33", "a", "b"}, {"-prefix", "10/8", "a", "b"}} {
+		if _, err := parseDiff(args); err == nil {
+			t.Errorf("TestParseDiff FAILED: %v should be rejected", args)
+		}
+	}
+}
+
+func TestLoadRound(t *testing.T) {
+	conn := openTestSQLite(t, "rounds.db")
+	defer conn.Close()
+	if err := conn.Save(types.Tasks{{IP: 1 << 24, Vantage: "eu", Ping: true}, {IP: 2 << 24, Vantage: "eu"}}); err != nil {
+		t.Fatalf("Cannot save: %v", err)
+	}
+	env := &envStruct{dbConn: conn}
+
+	s := snapshot.New("eu", false)
+	s.Add(types.Task{IP: 1 << 24})
+	file := filepath.Join(t.TempDir(), "before.wps")
+	var buf bytes.Buffer
+	s.Write(&buf)
+	os.WriteFile(file, buf.Bytes(), 0644)
+
+	before, err := env.loadRound(file, 0, 1<<32-1)
+	if err != nil {
+		t.Fatalf("TestLoadRound FAILED: %v", err)
+	}
+	after, err := env.loadRound("db:eu", 0, 1<<32-1)
+	if err != nil {
+		t.Fatalf("TestLoadRound FAILED: %v", err)
+	}
+	if probed, responsive := after.Counts(); probed != 2 || responsive != 1 {
+		t.Errorf("TestLoadRound FAILED: round from database has %d probed, %d responsive", probed, responsive)
+	}
+
+	deltas, _ := snapshot.Diff(before, after, 0, 1<<32-1, 24, func(uint32, bool) error { return nil })
+	if len(deltas) != 1 || deltas[0].Added != 1 {
+		t.Errorf("TestLoadRound FAILED: deltas %+v", deltas)
+	}
+	if _, err := env.loadRound(filepath.Join(t.TempDir(), "missing.wps"), 0, 1<<32-1); err == nil {
+		t.Errorf("TestLoadRound FAILED: missing snapshot loaded")
+	}
+}
+
+func TestPrintDeltas(t *testing.T) {
+	deltas := []snapshot.Delta{
+		{Start: 134744064, Prefix: 24, Before: 3, After: 4, Added: 2, Removed: 1},
+		{Start: 134744320, Prefix: 24, Before: 1, After: 0, Removed: 1},
+	}
+
+	var b bytes.Buffer
+	printDeltas(&b, deltas)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], "8.8.8.0/24") || !strings.Contains(lines[1], "+2") ||
+		!strings.HasPrefix(lines[3], "TOTAL") || !strings.Contains(lines[3], "+2") || !strings.Contains(lines[3], "-2") {
+		t.Errorf("FAILED: wrong table:\n%s", b.String())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/utils"
+)
+
+var drainTimeout, drainTimeoutErr = time.ParseDuration(getEnv("DRAIN_TIMEOUT", "1m"))
+
+// Releaser gives range lease back, so addresses from next are handed out again.
+// Coordinator continues range from next, databases hand out the whole unit containing it.
+type Releaser interface {
+	Release(next uint32) error
+}
+
+// drain stops taking new tasks. Scan finishes when in-flight probes are done
+// and results are saved, or after DRAIN_TIMEOUT, whichever comes first.
+func (env *envStruct) drain(cancel context.CancelFunc) {
+	env.drainOnce.Do(func() {
+		env.log.Noticef("Draining, hard deadline in %v", drainTimeout)
+		close(env.drainCh)
+		// paused scheduler should see the end of tasks
+		env.tuning.update(func(s *settings) error {
+			s.Paused = false
+			return nil
+		})
+		time.AfterFunc(drainTimeout, func() {
+			env.log.Errorf("Drain deadline exceeded, stopping")
+			cancel()
+		})
+	})
+}
+
+// release gives back lease of range interrupted by drain
+func (env *envStruct) release() {
+	var next uint32
+	select {
+	case next = <-env.checkpointCh:
+	default:
+		return // range was not interrupted by drain
+	}
+
+	releaser, ok := env.dbConn.(Releaser)
+	if !ok {
+		return
+	}
+	if err := releaser.Release(next); err != nil {
+		env.log.Errorf("Cannot release lease at %s: %v", utils.IPToStr(next), err)
+		return
+	}
+	env.log.Noticef("Released lease, scanning should continue from %s", utils.IPToStr(next))
+}
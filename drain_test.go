@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/apsdehal/go-logger"
+	"github.com/golang/mock/gomock"
+	"github.com/nanorobocop/worldping/mocks"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+type releaserDB struct {
+	*mocks.MockDB
+	released []uint32
+}
+
+func (db *releaserDB) Release(next uint32) error {
+	db.released = append(db.released, next)
+	return nil
+}
+
+func TestDrain(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockDB := &releaserDB{MockDB: mocks.NewMockDB(mockCtrl)}
+
+	// lease resumed in the middle of unit
+	mockDB.EXPECT().GetOldestIP().Return(uint32(1<<24+16), nil).Times(1)
+	mockDB.EXPECT().UnitSize().Return(uint32(1 << 8)).AnyTimes()
+	mockDB.EXPECT().Save(gomock.Any()).Return(nil).Times(1)
+
+	mockEnv := &envStruct{dbConn: mockDB, pinger: mockPinger{}, drainCh: make(chan struct{}), checkpointCh: make(chan uint32, 1)}
+	mockEnv.log, _ = logger.New("worldping", 0, os.Stdout)
+	var cancel context.CancelFunc
+	mockEnv.ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	taskCh := make(chan types.Task)
+	resultCh := make(chan types.Task)
+	go mockEnv.getTasks(taskCh)
+	for i := 0; i < 3; i++ {
+		if task := <-taskCh; task.IP != 1<<24+16+uint32(i) {
+			t.Errorf("Task %d FAILED: %d", i, task.IP)
+		}
+	}
+
+	mockEnv.drain(cancel)
+	mockEnv.drain(cancel) // second signal is ignored
+
+	if _, ok := <-taskCh; ok {
+		t.Fatalf("FAILED: task channel should be closed on drain")
+	}
+
+	// in-flight results are saved before exit
+	mockEnv.wg.Add(1)
+	go mockEnv.sendStat(resultCh, flushInterval)
+	resultCh <- types.Task{IP: 1<<24 + 16}
+	close(resultCh)
+	done := make(chan struct{})
+	go func() {
+		mockEnv.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("FAILED: sendStat did not finish after drain")
+	}
+
+	mockEnv.release()
+	if len(mockDB.released) != 1 || mockDB.released[0] != 1<<24+19 {
+		t.Errorf("FAILED: released %v instead of [%d]", mockDB.released, 1<<24+19)
+	}
+	if mockEnv.ctx.Err() != nil {
+		t.Errorf("FAILED: context should not be cancelled before drain deadline")
+	}
+}
+
+func TestReleaseDB(t *testing.T) {
+	conn := openTestSQLite(t, "drain.db")
+	defer conn.Close()
+	if err := conn.Save(types.Tasks{{IP: 5}, {IP: 1<<16 + 5}}); err != nil {
+		t.Fatalf("Cannot save: %v", err)
+	}
+
+	env := &envStruct{dbConn: conn, checkpointCh: make(chan uint32, 1)}
+	env.log, _ = logger.New("worldping", 0, os.Stdout)
+	env.checkpointCh <- 1<<16 + 6
+	env.release()
+
+	// interrupted unit goes before never scanned ones
+	if ip, err := conn.GetOldestIP(); err != nil || ip != 1<<16 {
+		t.Errorf("FAILED: oldest IP %d after release, %v", ip, err)
+	}
+}
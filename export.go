@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/nanorobocop/worldping/db"
+	"github.com/nanorobocop/worldping/pkg/snapshot"
+	"github.com/nanorobocop/worldping/pkg/utils"
+)
+
+// exportOptions are arguments of export command
+type exportOptions struct {
+	vantage    string
+	rtt        bool
+	start, end uint32
+	file       string
+}
+
+// export writes snapshot of results of vantage point: "export [-vantage V] [-rtt] [-prefix CIDR] FILE",
+// "-" writes to stdout. Snapshot is restored with "import -format snapshot FILE".
+func (env *envStruct) export(args []string) {
+	opts, err := parseExport(args, vantage)
+	if err != nil {
+		env.log.Fatalf("Wrong export arguments: %v", err)
+	}
+	s, err := readRound(env.dbConn, opts.vantage, opts.rtt, opts.start, opts.end)
+	if err != nil {
+		env.log.Fatalf("Cannot read results: %v", err)
+	}
+
+	out := os.Stdout
+	if opts.file != "-" {
+		if out, err = os.Create(opts.file); err != nil {
+			env.log.Fatalf("Cannot create %s: %v", opts.file, err)
+		}
+	}
+	if err = s.Write(out); err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		env.log.Fatalf("Cannot write snapshot: %v", err)
+	}
+	probed, responsive := s.Counts()
+	env.log.Noticef("Exported %s to %s: %d /16 ranges, probed %d, pinged %d",
+		prefixStr(opts.start, opts.end), opts.file, len(s.Ranges), probed, responsive)
+}
+
+// readRound returns results of vantage point in range [start, end] from database as snapshot
+func readRound(conn db.DB, vantage string, rtt bool, start, end uint32) (*snapshot.Snapshot, error) {
+	reader, ok := conn.(db.ResultReader)
+	if !ok {
+		return nil, fmt.Errorf("reading results is not supported by database")
+	}
+	s := snapshot.New(vantage, rtt)
+	return s, reader.ReadResults(vantage, start, end, s.Add)
+}
+
+// parseExport parses "[-vantage V] [-rtt] [-prefix CIDR] FILE", vantage point is defaultVantage if not set
+func parseExport(args []string, defaultVantage string) (opts exportOptions, err error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&opts.vantage, "vantage", defaultVantage, "vantage point of results")
+	fs.BoolVar(&opts.rtt, "rtt", false, "keep RTT of responsive addresses")
+	prefix := fs.String("prefix", "0.0.0.0/0", "export results of prefix only")
+	if err = fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if opts.start, opts.end, err = parsePrefixRange(*prefix); err != nil {
+		return opts, err
+	}
+	if fs.NArg() != 1 {
+		return opts, fmt.Errorf("single file is expected")
+	}
+	opts.file = fs.Arg(0)
+	return opts, nil
+}
+
+// parsePrefixRange returns first and last address of IPv4 prefix
+func parsePrefixRange(s string) (start, end uint32, err error) {
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	ip := ipNet.IP.To4()
+	if ip == nil {
+		return 0, 0, fmt.Errorf("%s is not IPv4 prefix", s)
+	}
+	ones, _ := ipNet.Mask.Size()
+	start = uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+	return start, start | uint32(1<<uint(32-ones)-1), nil
+}
+
+// prefixStr returns range of prefix as "START-END"
+func prefixStr(start, end uint32) string {
+	return utils.IPToStr(start) + "-" + utils.IPToStr(end)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apsdehal/go-logger"
+	"github.com/nanorobocop/worldping/db"
+	"github.com/nanorobocop/worldping/pkg/importer"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func TestParseExport(t *testing.T) {
+	opts, err := parseExport([]string{"-rtt", "-prefix", "192.0.2.0/24", "round.wps"}, "eu")
+	expected := exportOptions{vantage: "eu", rtt: true, start: 3221225984, end: 3221226239, file: "round.wps"}
+	if err != nil || opts != expected {
+		t.Errorf("TestParseExport FAILED: %+v, %v (actual) != %+v (expected)", opts, err, expected)
+	}
+
+	for _, args := range [][]string{{}, {"-prefix", "2001:db8::/32", "round.wps"}, {"a.wps", "b.wps"}} {
+		if _, err := parseExport(args, ""); err == nil {
+			t.Errorf("TestParseExport FAILED: %v should be rejected", args)
+		}
+	}
+}
+
+func openTestSQLite(t *testing.T, name string) *db.SQLite {
+	conn := &db.SQLite{DBPath: filepath.Join(t.TempDir(), name), DBTable: "worldping", UnitPrefix: 16}
+	if err := conn.Open(); err != nil {
+		t.Fatalf("Cannot open %s: %v", name, err)
+	}
+	if err := conn.CreateTable(); err != nil {
+		t.Fatalf("Cannot create table: %v", err)
+	}
+	return conn
+}
+
+func TestExportRestore(t *testing.T) {
+	src := openTestSQLite(t, "src.db")
+	defer src.Close()
+	when := time.Now().Add(-time.Hour).Truncate(time.Second)
+	results := types.Tasks{
+		{IP: 1 << 24, Vantage: "eu", Ping: true, RTT: 2500 * time.Microsecond, Time: when},
+		{IP: 1<<24 + 1, Vantage: "eu", Time: when},
+		{IP: 2 << 24, Vantage: "eu", Ping: true, Time: when},
+		{IP: 1 << 24, Vantage: "us", Ping: true, Time: when},
+	}
+	if err := src.Save(results); err != nil {
+		t.Fatalf("Cannot save: %v", err)
+	}
+
+	env := &envStruct{dbConn: src}
+	env.log, _ = logger.New("worldping", 0, os.Stdout)
+	file := filepath.Join(t.TempDir(), "round.wps")
+	env.export([]string{"-vantage", "eu", "-rtt", "-prefix", "1.0.0.0/8", file})
+
+	dst := openTestSQLite(t, "dst.db")
+	defer dst.Close()
+	in, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("Cannot open snapshot: %v", err)
+	}
+	defer in.Close()
+	total, pinged, err := importFile(in, importOptions{format: importer.Snapshot}, dbPublishSize, dst.Save)
+	if err != nil || total != 2 || pinged != 1 {
+		t.Fatalf("TestExportRestore FAILED: total %d, pinged %d, %v (actual) != total 2, pinged 1 (expected)", total, pinged, err)
+	}
+
+	var restored types.Tasks
+	dst.ReadResults("eu", 0, 1<<32-1, func(r types.Task) error {
+		restored = append(restored, r)
+		return nil
+	})
+	if len(restored) != 2 || restored[0].IP != 1<<24 || !restored[0].Ping || restored[1].Ping || !restored[0].Time.Equal(when) ||
+		restored[0].RTT != 2500*time.Microsecond || restored[1].RTT != 0 {
+		t.Errorf("TestExportRestore FAILED: restored %+v", restored)
+	}
+}
@@ -5,11 +5,10 @@ go 1.16
 require (
 	github.com/StackExchange/wmi v0.0.0-20210224194228-fe8f1750fd46 // indirect
 	github.com/apsdehal/go-logger v0.0.0-20190515212710-b0d6ccfee0e6
-	github.com/digineo/go-ping v1.0.1
 	github.com/go-ole/go-ole v1.2.5 // indirect
 	github.com/golang/mock v1.5.0
 	github.com/lib/pq v1.10.1
 	github.com/shirou/gopsutil v3.21.4+incompatible
-	golang.org/x/net v0.0.0-20210505214959-0714010a04ed // indirect
-	golang.org/x/sys v0.0.0-20210503173754-0981d6026fa6 // indirect
+	golang.org/x/net v0.0.0-20210505214959-0714010a04ed
+	modernc.org/sqlite v1.17.3
 )
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nanorobocop/worldping/pkg/importer"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+// importOptions are arguments of import command
+type importOptions struct {
+	format  string
+	source  string // empty - name of scanner
+	vantage string // empty - vantage point of snapshot or source
+	files   []string
+}
+
+// importResults saves results of other scanners or snapshot: "import -format FORMAT [-source TAG] [-vantage V] FILE...",
+// "-" reads standard input. Results keep time of probe and never overwrite newer ones.
+func (env *envStruct) importResults(args []string) {
+	opts, err := parseImport(args)
+	if err != nil {
+		env.log.Fatalf("Wrong import arguments: %v", err)
+	}
+	for _, name := range opts.files {
+		in := os.Stdin
+		if name != "-" {
+			if in, err = os.Open(name); err != nil {
+				env.log.Fatalf("Cannot open %s: %v", name, err)
+			}
+		}
+		total, pinged, err := importFile(in, opts, dbPublishSize, env.dbConn.Save)
+		in.Close()
+		if err != nil {
+			env.log.Fatalf("Cannot import %s: %v", name, err)
+		}
+		env.log.Noticef("Imported %s: total %d, pinged %d", name, total, pinged)
+	}
+}
+
+// parseImport parses "-format FORMAT [-source TAG] [-vantage V] FILE..."
+func parseImport(args []string) (opts importOptions, err error) {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&opts.format, "format", "", "format of results: "+importer.ZMap+", "+importer.MasscanJSON+", "+importer.MasscanList+", "+
+		importer.MasscanBinary+" or "+importer.Snapshot)
+	fs.StringVar(&opts.source, "source", "", "source stored with results (default - scanner name)")
+	fs.StringVar(&opts.vantage, "vantage", "", "vantage point stored with results (default - of snapshot or source)")
+	if err = fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if opts.format == "" {
+		return opts, fmt.Errorf("format is missing")
+	}
+	if opts.files = fs.Args(); len(opts.files) == 0 {
+		return opts, fmt.Errorf("file is missing")
+	}
+	return opts, nil
+}
+
+// importFile reads results from r and saves them in batches of up to size addresses.
+// Results of the same address in file are merged, responsive one wins: unresponsive result
+// of address saved as responsive in previous batch is skipped.
+func importFile(r io.Reader, opts importOptions, size int, save func(types.Tasks) error) (total, pinged int, err error) {
+	batch := make(types.Tasks, 0, size)
+	index := make(map[uint32]int, size)
+	saved := make(map[uint32]bool) // addresses of previous batches, true if responsive
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		for _, t := range batch {
+			if t.Ping {
+				pinged++
+			}
+			saved[t.IP] = saved[t.IP] || t.Ping
+		}
+		total += len(batch)
+		err := save(batch)
+		batch = make(types.Tasks, 0, size)
+		index = make(map[uint32]int, size)
+		return err
+	}
+
+	err = importer.Read(opts.format, r, func(t types.Task) error {
+		if opts.source != "" {
+			t.Source = opts.source
+		}
+		if opts.vantage != "" {
+			t.Vantage = opts.vantage
+		} else if t.Vantage == "" {
+			t.Vantage = t.Source
+		}
+		if i, ok := index[t.IP]; ok {
+			if t.Ping && !batch[i].Ping {
+				batch[i] = t
+			}
+			return nil
+		}
+		if responsive, ok := saved[t.IP]; ok && (responsive || !t.Ping) {
+			return nil
+		}
+		if len(batch) == size {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		index[t.IP] = len(batch)
+		batch = append(batch, t)
+		return nil
+	})
+	if err != nil {
+		return total, pinged, err
+	}
+	return total, pinged, flush()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nanorobocop/worldping/pkg/importer"
+	"github.com/nanorobocop/worldping/pkg/types"
+	"github.com/nanorobocop/worldping/pkg/utils"
+)
+
+func TestParseImport(t *testing.T) {
+	opts, err := parseImport([]string{"-format", "zmap", "-source", "census", "a.csv", "-"})
+	expected := importOptions{format: "zmap", source: "census", files: []string{"a.csv", "-"}}
+	if err != nil || !reflect.DeepEqual(opts, expected) {
+		t.Errorf("TestParseImport FAILED: %+v, %v (actual) != %+v (expected)", opts, err, expected)
+	}
+
+	for _, args := range [][]string{{"a.csv"}, {"-format", "zmap"}, {"-wrong", "a.csv"}} {
+		if _, err := parseImport(args); err == nil {
+			t.Errorf("TestParseImport FAILED: %v should be rejected", args)
+		}
+	}
+}
+
+func TestImportFile(t *testing.T) {
+	input := "open tcp 443 192.0.2.1 1609459200\n" +
+		"closed tcp 22 192.0.2.2 1609459200\n" +
+		"open tcp 80 192.0.2.2 1609459200\n" +
+		"open tcp 80 192.0.2.1 1609459200\n" +
+		"open tcp 80 192.0.2.3 1609459200\n"
+
+	var batches []types.Tasks
+	save := func(batch types.Tasks) error {
+		batches = append(batches, batch)
+		return nil
+	}
+	opts := importOptions{format: importer.MasscanList}
+	total, pinged, err := importFile(strings.NewReader(input), opts, 2, save)
+	if err != nil || total != 3 || pinged != 3 {
+		t.Fatalf("TestImportFile FAILED: total %d, pinged %d, %v (actual) != total 3, pinged 3 (expected)", total, pinged, err)
+	}
+
+	// ports of the same address are merged in batch, first one is kept (closed port is responsive too),
+	// vantage is source by default
+	expected := [][]string{{"192.0.2.1 tcp:443", "192.0.2.2 tcp:22"}, {"192.0.2.3 tcp:80"}}
+	if len(batches) != len(expected) {
+		t.Fatalf("TestImportFile FAILED: %d batches (actual) != %d (expected)", len(batches), len(expected))
+	}
+	for i, batch := range batches {
+		var actual []string
+		for _, r := range batch {
+			if r.Vantage != importer.SourceMasscan || r.Source != importer.SourceMasscan {
+				t.Errorf("TestImportFile FAILED: vantage %q, source %q (actual) != %q (expected)", r.Vantage, r.Source, importer.SourceMasscan)
+			}
+			actual = append(actual, utils.IPToStr(r.IP)+" "+r.Probe)
+		}
+		if !reflect.DeepEqual(actual, expected[i]) {
+			t.Errorf("TestImportFile FAILED: batch %d %v (actual) != %v (expected)", i, actual, expected[i])
+		}
+	}
+
+	// responsive address of previous batch is not overwritten by unresponsive result, but the opposite is
+	input = "saddr,success\n192.0.2.1,1\n192.0.2.2,0\n192.0.2.3,1\n192.0.2.1,0\n192.0.2.2,1\n"
+	batches = nil
+	total, pinged, err = importFile(strings.NewReader(input), importOptions{format: importer.ZMap}, 2, save)
+	if err != nil || total != 4 || pinged != 3 {
+		t.Fatalf("TestImportFile FAILED: total %d, pinged %d, %v (actual) != total 4, pinged 3 (expected)", total, pinged, err)
+	}
+	if len(batches) != 2 || len(batches[1]) != 2 || batches[1][1].IP != 3221225986 || !batches[1][1].Ping {
+		t.Errorf("TestImportFile FAILED: batches %+v", batches)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nanorobocop/worldping/db"
+)
+
+// migrate applies schema migrations or prints their status, without starting scan
+func (env *envStruct) migrate(args []string) {
+	migrator, ok := env.dbConn.(db.Migrator)
+	if !ok {
+		env.log.Fatalf("Schema migrations are not supported by database")
+	}
+	if err := env.dbConn.Open(); err != nil {
+		env.log.Fatalf("Cannot open connection to database: %+v", err)
+	}
+	defer env.dbConn.Close()
+	if err := env.dbConn.Ping(); err != nil {
+		env.log.Fatalf("Cannot ping DB: %v", err)
+	}
+
+	subcommand := "up"
+	if len(args) > 0 {
+		subcommand = args[0]
+	}
+	switch subcommand {
+	case "up":
+		if err := migrator.Migrate(); err != nil {
+			env.log.Fatalf("Cannot migrate schema: %v", err)
+		}
+		env.log.Notice("Schema is up to date")
+	case "status":
+		migrations, err := migrator.Migrations()
+		if err != nil {
+			env.log.Fatalf("Cannot get schema migrations: %v", err)
+		}
+		printMigrations(os.Stdout, migrations)
+	default:
+		env.log.Fatalf("Unknown migrate subcommand %q (should be up or status)", subcommand)
+	}
+}
+
+// printMigrations writes table of schema migrations
+func printMigrations(out io.Writer, migrations []db.Migration) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tOPTION\tAPPLIED\tSTATUS")
+	for _, m := range migrations {
+		applied := "-"
+		if !m.Applied.IsZero() {
+			applied = m.Applied.Format(time.RFC3339)
+		}
+		status := "pending"
+		switch {
+		case m.Unknown:
+			status = "unknown"
+		case !m.Applied.IsZero():
+			status = "applied"
+		case m.Disabled:
+			status = "disabled"
+		}
+		option := "-"
+		if m.Option != "" {
+			option = m.Option
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", m.Version, m.Name, option, applied, status)
+	}
+	w.Flush()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nanorobocop/worldping/db"
+)
+
+func TestPrintMigrations(t *testing.T) {
+	now := time.Now()
+	migrations := []db.Migration{
+		{Version: 1, Name: "create_results", Applied: now},
+		{Version: 2, Name: "add_probe_columns"},
+		{Version: 3, Name: "inet_addresses", Option: db.OptionInet, Disabled: true},
+		{Version: 4, Name: "from_future", Applied: now, Unknown: true},
+	}
+
+	var b bytes.Buffer
+	printMigrations(&b, migrations)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	statuses := []string{"applied", "pending", "disabled", "unknown"}
+	if len(lines) != len(statuses)+1 || !strings.Contains(lines[1], "create_results") {
+		t.Fatalf("FAILED: wrong table:\n%s", b.String())
+	}
+	for i, status := range statuses {
+		if !strings.HasSuffix(lines[i+1], status) {
+			t.Errorf("FAILED: line %q should have status %s", lines[i+1], status)
+		}
+	}
+}
@@ -1,11 +1,12 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/nanorobocop/worldping/db (interfaces: DB)
+// Source: github.com/nanorobocop/worldping/db (interfaces: DB,TraceStore,PTRStore,BatchStore,RescanQueue)
 
 // Package mocks is a generated GoMock package.
 package mocks
 
 import (
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	types "github.com/nanorobocop/worldping/pkg/types"
@@ -133,3 +134,256 @@ func (mr *MockDBMockRecorder) Save(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockDB)(nil).Save), arg0)
 }
+
+// UnitSize mocks base method.
+func (m *MockDB) UnitSize() uint32 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnitSize")
+	ret0, _ := ret[0].(uint32)
+	return ret0
+}
+
+// UnitSize indicates an expected call of UnitSize.
+func (mr *MockDBMockRecorder) UnitSize() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnitSize", reflect.TypeOf((*MockDB)(nil).UnitSize))
+}
+
+// MockTraceStore is a mock of TraceStore interface.
+type MockTraceStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockTraceStoreMockRecorder
+}
+
+// MockTraceStoreMockRecorder is the mock recorder for MockTraceStore.
+type MockTraceStoreMockRecorder struct {
+	mock *MockTraceStore
+}
+
+// NewMockTraceStore creates a new mock instance.
+func NewMockTraceStore(ctrl *gomock.Controller) *MockTraceStore {
+	mock := &MockTraceStore{ctrl: ctrl}
+	mock.recorder = &MockTraceStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTraceStore) EXPECT() *MockTraceStoreMockRecorder {
+	return m.recorder
+}
+
+// GetRepresentatives mocks base method.
+func (m *MockTraceStore) GetRepresentatives() ([]uint32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRepresentatives")
+	ret0, _ := ret[0].([]uint32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRepresentatives indicates an expected call of GetRepresentatives.
+func (mr *MockTraceStoreMockRecorder) GetRepresentatives() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRepresentatives", reflect.TypeOf((*MockTraceStore)(nil).GetRepresentatives))
+}
+
+// SaveTrace mocks base method.
+func (m *MockTraceStore) SaveTrace(arg0 types.Trace) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveTrace", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveTrace indicates an expected call of SaveTrace.
+func (mr *MockTraceStoreMockRecorder) SaveTrace(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveTrace", reflect.TypeOf((*MockTraceStore)(nil).SaveTrace), arg0)
+}
+
+// MockPTRStore is a mock of PTRStore interface.
+type MockPTRStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockPTRStoreMockRecorder
+}
+
+// MockPTRStoreMockRecorder is the mock recorder for MockPTRStore.
+type MockPTRStoreMockRecorder struct {
+	mock *MockPTRStore
+}
+
+// NewMockPTRStore creates a new mock instance.
+func NewMockPTRStore(ctrl *gomock.Controller) *MockPTRStore {
+	mock := &MockPTRStore{ctrl: ctrl}
+	mock.recorder = &MockPTRStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPTRStore) EXPECT() *MockPTRStoreMockRecorder {
+	return m.recorder
+}
+
+// GetPTRTargets mocks base method.
+func (m *MockPTRStore) GetPTRTargets(arg0, arg1 uint32, arg2 time.Duration) ([]uint32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPTRTargets", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]uint32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPTRTargets indicates an expected call of GetPTRTargets.
+func (mr *MockPTRStoreMockRecorder) GetPTRTargets(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPTRTargets", reflect.TypeOf((*MockPTRStore)(nil).GetPTRTargets), arg0, arg1, arg2)
+}
+
+// SavePTR mocks base method.
+func (m *MockPTRStore) SavePTR(arg0 []types.PTR) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SavePTR", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SavePTR indicates an expected call of SavePTR.
+func (mr *MockPTRStoreMockRecorder) SavePTR(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SavePTR", reflect.TypeOf((*MockPTRStore)(nil).SavePTR), arg0)
+}
+
+// MockBatchStore is a mock of BatchStore interface.
+type MockBatchStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockBatchStoreMockRecorder
+}
+
+// MockBatchStoreMockRecorder is the mock recorder for MockBatchStore.
+type MockBatchStoreMockRecorder struct {
+	mock *MockBatchStore
+}
+
+// NewMockBatchStore creates a new mock instance.
+func NewMockBatchStore(ctrl *gomock.Controller) *MockBatchStore {
+	mock := &MockBatchStore{ctrl: ctrl}
+	mock.recorder = &MockBatchStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBatchStore) EXPECT() *MockBatchStoreMockRecorder {
+	return m.recorder
+}
+
+// SaveBatch mocks base method.
+func (m *MockBatchStore) SaveBatch(arg0 types.Batch) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveBatch", arg0)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveBatch indicates an expected call of SaveBatch.
+func (mr *MockBatchStoreMockRecorder) SaveBatch(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBatch", reflect.TypeOf((*MockBatchStore)(nil).SaveBatch), arg0)
+}
+
+// MockRescanQueue is a mock of RescanQueue interface.
+type MockRescanQueue struct {
+	ctrl     *gomock.Controller
+	recorder *MockRescanQueueMockRecorder
+}
+
+// MockRescanQueueMockRecorder is the mock recorder for MockRescanQueue.
+type MockRescanQueueMockRecorder struct {
+	mock *MockRescanQueue
+}
+
+// NewMockRescanQueue creates a new mock instance.
+func NewMockRescanQueue(ctrl *gomock.Controller) *MockRescanQueue {
+	mock := &MockRescanQueue{ctrl: ctrl}
+	mock.recorder = &MockRescanQueueMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRescanQueue) EXPECT() *MockRescanQueueMockRecorder {
+	return m.recorder
+}
+
+// EnqueueRescan mocks base method.
+func (m *MockRescanQueue) EnqueueRescan(arg0 types.Rescan) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnqueueRescan", arg0)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EnqueueRescan indicates an expected call of EnqueueRescan.
+func (mr *MockRescanQueueMockRecorder) EnqueueRescan(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnqueueRescan", reflect.TypeOf((*MockRescanQueue)(nil).EnqueueRescan), arg0)
+}
+
+// FinishRescan mocks base method.
+func (m *MockRescanQueue) FinishRescan(arg0 int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FinishRescan", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FinishRescan indicates an expected call of FinishRescan.
+func (mr *MockRescanQueueMockRecorder) FinishRescan(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FinishRescan", reflect.TypeOf((*MockRescanQueue)(nil).FinishRescan), arg0)
+}
+
+// ReleaseRescan mocks base method.
+func (m *MockRescanQueue) ReleaseRescan(arg0 int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseRescan", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseRescan indicates an expected call of ReleaseRescan.
+func (mr *MockRescanQueueMockRecorder) ReleaseRescan(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseRescan", reflect.TypeOf((*MockRescanQueue)(nil).ReleaseRescan), arg0)
+}
+
+// GetRescans mocks base method.
+func (m *MockRescanQueue) GetRescans() ([]types.Rescan, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRescans")
+	ret0, _ := ret[0].([]types.Rescan)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRescans indicates an expected call of GetRescans.
+func (mr *MockRescanQueueMockRecorder) GetRescans() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRescans", reflect.TypeOf((*MockRescanQueue)(nil).GetRescans))
+}
+
+// NextRescan mocks base method.
+func (m *MockRescanQueue) NextRescan() (types.Rescan, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NextRescan")
+	ret0, _ := ret[0].(types.Rescan)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// NextRescan indicates an expected call of NextRescan.
+func (mr *MockRescanQueueMockRecorder) NextRescan() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NextRescan", reflect.TypeOf((*MockRescanQueue)(nil).NextRescan))
+}
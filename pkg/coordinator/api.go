@@ -0,0 +1,125 @@
+// Package coordinator implements service handing out work to workers.
+// Coordinator is the only component talking to database,
+// workers use Client which implements db.DB over HTTP API.
+package coordinator
+
+import (
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+// API paths
+const (
+	PathPing      = "/v1/ping"
+	PathRegister  = "/v1/register"
+	PathWork      = "/v1/work"
+	PathResults   = "/v1/results"
+	PathMaxIP     = "/v1/maxip"
+	PathHeartbeat = "/v1/heartbeat"
+	PathWorkers   = "/v1/workers"
+	PathRelease   = "/v1/release"
+
+	PathRescans       = "/v1/rescans" // GET lists queue, POST enqueues prefix
+	PathRescanNext    = "/v1/rescans/next"
+	PathRescanDone    = "/v1/rescans/done"
+	PathRescanRelease = "/v1/rescans/release"
+)
+
+// RegisterRequest is sent by worker at start, coordinator assigns ID if empty
+type RegisterRequest struct {
+	ID           string   `json:"id"`
+	Vantage      string   `json:"vantage"`
+	Hostname     string   `json:"hostname"`
+	Version      string   `json:"version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// RegisterResponse contains ID of registered worker and size of work units
+type RegisterResponse struct {
+	ID       string `json:"id"`
+	UnitSize uint32 `json:"unit_size"`
+}
+
+// WorkRequest is sent by worker to pull next work unit
+type WorkRequest struct {
+	ID      string `json:"id"`
+	Vantage string `json:"vantage"`
+}
+
+// Lease is range of IPs handed out to worker.
+// Next is where scanning should start: range could be partially scanned by worker released it.
+type Lease struct {
+	Start   uint32    `json:"start"`
+	End     uint32    `json:"end"`
+	Next    uint32    `json:"next"`
+	Vantage string    `json:"vantage,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// ReleaseRequest is sent by worker giving up its lease (e.g. before upgrade).
+// Addresses before Next are scanned and their results are submitted.
+type ReleaseRequest struct {
+	ID   string `json:"id"`
+	Next uint32 `json:"next"`
+}
+
+// ResultsRequest is sent by worker to submit results.
+// Identified batch is saved once, however many times it is submitted.
+type ResultsRequest struct {
+	ID      string         `json:"id"`
+	Batch   *types.BatchID `json:"batch,omitempty"`
+	Results types.Tasks    `json:"results"`
+}
+
+// ResultsResponse tells if batch was saved before
+type ResultsResponse struct {
+	Duplicate bool `json:"duplicate"`
+}
+
+// MaxIPResponse contains maximum IP in database
+type MaxIPResponse struct {
+	IP uint32 `json:"ip"`
+}
+
+// HeartbeatRequest is sent by worker periodically
+type HeartbeatRequest struct {
+	ID       string  `json:"id"`
+	Rate     float64 `json:"rate"`     // probes per second since previous heartbeat
+	Probed   uint64  `json:"probed"`   // probes since worker start
+	Progress uint32  `json:"progress"` // last IP handed out for probing
+}
+
+// Worker describes registered worker in fleet.
+// Worker is stale if it did not send heartbeat for a while.
+type Worker struct {
+	ID           string    `json:"id"`
+	Vantage      string    `json:"vantage"`
+	Hostname     string    `json:"hostname"`
+	Version      string    `json:"version"`
+	Capabilities []string  `json:"capabilities"`
+	Registered   time.Time `json:"registered"`
+	LastSeen     time.Time `json:"last_seen"`
+	Rate         float64   `json:"rate"`
+	Probed       uint64    `json:"probed"`
+	Progress     uint32    `json:"progress"`
+	Lease        *Lease    `json:"lease,omitempty"`
+	Stale        bool      `json:"stale"`
+}
+
+// EnqueueResponse contains ID of enqueued rescan
+type EnqueueResponse struct {
+	ID int64 `json:"id"`
+}
+
+// NextRescanResponse contains prefix to rescan, OK is false if queue is empty
+type NextRescanResponse struct {
+	Rescan types.Rescan `json:"rescan"`
+	OK     bool         `json:"ok"`
+}
+
+// RescanDoneRequest is sent by worker when all addresses of prefix are probed,
+// or when prefix is given back unfinished (release)
+type RescanDoneRequest struct {
+	ID int64 `json:"id"`
+}
@@ -0,0 +1,185 @@
+package coordinator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+// Client implements db.DB on top of coordinator API,
+// so worker does not need access to database
+type Client struct {
+	URL          string
+	ID           string
+	Vantage      string
+	Hostname     string
+	Version      string
+	Capabilities []string
+
+	c        *http.Client
+	unitSize uint32
+}
+
+// Open registers worker at coordinator
+func (c *Client) Open() error {
+	req := RegisterRequest{ID: c.ID, Vantage: c.Vantage, Hostname: c.Hostname, Version: c.Version, Capabilities: c.Capabilities}
+	var resp RegisterResponse
+	if err := c.post(PathRegister, req, &resp); err != nil {
+		return err
+	}
+	c.ID = resp.ID
+	c.unitSize = resp.UnitSize
+	return nil
+}
+
+// UnitSize returns size of work units configured at coordinator
+func (c *Client) UnitSize() uint32 {
+	return c.unitSize
+}
+
+// Heartbeat reports worker stats to coordinator
+func (c *Client) Heartbeat(req HeartbeatRequest) error {
+	req.ID = c.ID
+	return c.post(PathHeartbeat, req, nil)
+}
+
+// Workers returns fleet of workers registered at coordinator
+func (c *Client) Workers() (workers []Worker, err error) {
+	resp, err := c.httpClient().Get(c.url(PathWorkers))
+	if err != nil {
+		return nil, err
+	}
+	err = checkResponse(resp, &workers)
+	return workers, err
+}
+
+// Ping checks coordinator and its connection to database
+func (c *Client) Ping() error {
+	resp, err := c.httpClient().Get(c.url(PathPing))
+	if err != nil {
+		return err
+	}
+	return checkResponse(resp, nil)
+}
+
+// CreateTable does nothing: tables are created by coordinator
+func (c *Client) CreateTable() error {
+	return nil
+}
+
+// GetMaxIP returns maximum IP in database
+func (c *Client) GetMaxIP() (uint32, error) {
+	var resp MaxIPResponse
+	err := c.post(PathMaxIP, struct{}{}, &resp)
+	return resp.IP, err
+}
+
+// GetOldestIP pulls next work unit, coordinator leases it to this worker.
+// Returned IP is inside the unit if it was partially scanned before.
+func (c *Client) GetOldestIP() (uint32, error) {
+	var lease Lease
+	err := c.post(PathWork, WorkRequest{ID: c.ID, Vantage: c.Vantage}, &lease)
+	return lease.Next, err
+}
+
+// Release gives lease back to coordinator, addresses from next are not scanned yet
+func (c *Client) Release(next uint32) error {
+	return c.post(PathRelease, ReleaseRequest{ID: c.ID, Next: next}, nil)
+}
+
+// Save submits results
+func (c *Client) Save(results types.Tasks) error {
+	return c.post(PathResults, ResultsRequest{ID: c.ID, Results: results}, nil)
+}
+
+// SaveBatch submits identified batch, it is saved once if submission is retried
+func (c *Client) SaveBatch(batch types.Batch) (duplicate bool, err error) {
+	var resp ResultsResponse
+	err = c.post(PathResults, ResultsRequest{ID: c.ID, Batch: &batch.ID, Results: batch.Results}, &resp)
+	return resp.Duplicate, err
+}
+
+// EnqueueRescan adds prefix to coordinator rescan queue
+func (c *Client) EnqueueRescan(rescan types.Rescan) (int64, error) {
+	var resp EnqueueResponse
+	err := c.post(PathRescans, rescan, &resp)
+	return resp.ID, err
+}
+
+// NextRescan takes prefix to rescan from coordinator queue
+func (c *Client) NextRescan() (types.Rescan, bool, error) {
+	var resp NextRescanResponse
+	err := c.post(PathRescanNext, WorkRequest{ID: c.ID, Vantage: c.Vantage}, &resp)
+	return resp.Rescan, resp.OK, err
+}
+
+// FinishRescan reports prefix as rescanned
+func (c *Client) FinishRescan(id int64) error {
+	return c.post(PathRescanDone, RescanDoneRequest{ID: id}, nil)
+}
+
+// ReleaseRescan gives unfinished prefix back to coordinator queue
+func (c *Client) ReleaseRescan(id int64) error {
+	return c.post(PathRescanRelease, RescanDoneRequest{ID: id}, nil)
+}
+
+// GetRescans returns coordinator rescan queue
+func (c *Client) GetRescans() (rescans []types.Rescan, err error) {
+	resp, err := c.httpClient().Get(c.url(PathRescans))
+	if err != nil {
+		return nil, err
+	}
+	err = checkResponse(resp, &rescans)
+	return rescans, err
+}
+
+// Close does nothing: there is no persistent connection
+func (c *Client) Close() error {
+	return nil
+}
+
+// httpClient is created on first request, so Client could be used
+// without registration (e.g. for operator commands)
+func (c *Client) httpClient() *http.Client {
+	if c.c == nil {
+		c.c = &http.Client{Timeout: time.Minute}
+	}
+	return c.c
+}
+
+func (c *Client) url(path string) string {
+	return strings.TrimSuffix(c.URL, "/") + path
+}
+
+// post sends JSON request and decodes JSON response into v (if not nil)
+func (c *Client) post(path string, req, v interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Post(c.url(path), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	return checkResponse(resp, v)
+}
+
+// checkResponse closes response body, returns error for non-200 status
+func checkResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("coordinator: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
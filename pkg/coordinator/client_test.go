@@ -0,0 +1,245 @@
+package coordinator
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/nanorobocop/worldping/mocks"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func TestClient(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockDB := mocks.NewMockDB(mockCtrl)
+	server := httptest.NewServer(&Server{DB: mockDB, LeaseTimeout: time.Minute, StaleAfter: time.Minute})
+	defer server.Close()
+
+	mockDB.EXPECT().UnitSize().Return(uint32(1 << 16)).AnyTimes()
+	client := &Client{URL: server.URL + "/", Hostname: "host", Version: "1.0", Capabilities: []string{"echo"}}
+	if err := client.Open(); err != nil {
+		t.Fatalf("Cannot register: %v", err)
+	}
+	if !strings.HasPrefix(client.ID, "worker-") || len(client.ID) != len("worker-")+16 || client.UnitSize() != 1<<16 {
+		t.Errorf("FAILED: wrong worker ID %q or unit size %d", client.ID, client.UnitSize())
+	}
+	// IDs are random, so workers registered after coordinator restart do not get IDs of previous ones
+	restarted := httptest.NewServer(&Server{DB: mockDB})
+	defer restarted.Close()
+	other := &Client{URL: restarted.URL}
+	if err := other.Open(); err != nil || other.ID == client.ID {
+		t.Errorf("FAILED: worker ID %q is reused, %v", other.ID, err)
+	}
+
+	mockDB.EXPECT().Ping().Return(nil).Times(1)
+	if err := client.Ping(); err != nil {
+		t.Errorf("FAILED: ping: %v", err)
+	}
+
+	mockDB.EXPECT().Ping().Return(errors.New("some error")).Times(1)
+	if err := client.Ping(); err == nil {
+		t.Errorf("FAILED: ping error expected")
+	}
+
+	mockDB.EXPECT().GetOldestIP().Return(uint32(1<<24), nil).Times(1)
+	if ip, err := client.GetOldestIP(); err != nil || ip != 1<<24 {
+		t.Errorf("FAILED: work: %d, %v", ip, err)
+	}
+
+	mockDB.EXPECT().GetOldestIP().Return(uint32(0), errors.New("some error")).Times(1)
+	if _, err := client.GetOldestIP(); err == nil {
+		t.Errorf("FAILED: work error expected")
+	}
+
+	results := types.Tasks{{IP: 1, Ping: true, Probe: "echo", Replies: 1}, {IP: 2}}
+	mockDB.EXPECT().Save(results).Return(nil).Times(1)
+	if err := client.Save(results); err != nil {
+		t.Errorf("FAILED: save: %v", err)
+	}
+
+	mockDB.EXPECT().Save(results).Return(errors.New("some error")).Times(1)
+	if err := client.Save(results); err == nil {
+		t.Errorf("FAILED: save error expected")
+	}
+
+	mockDB.EXPECT().GetMaxIP().Return(uint32(42), nil).Times(1)
+	if ip, err := client.GetMaxIP(); err != nil || ip != 42 {
+		t.Errorf("FAILED: max IP: %d, %v", ip, err)
+	}
+
+	if err := client.Heartbeat(HeartbeatRequest{Rate: 5, Probed: 10, Progress: 42}); err != nil {
+		t.Errorf("FAILED: heartbeat: %v", err)
+	}
+
+	workers, err := client.Workers()
+	if err != nil || len(workers) != 1 {
+		t.Fatalf("FAILED: workers: %+v, %v", workers, err)
+	}
+	if w := workers[0]; w.ID != client.ID || w.Hostname != "host" || w.Version != "1.0" || w.Probed != 10 || w.Progress != 42 || w.Stale {
+		t.Errorf("FAILED: wrong worker %+v", w)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Errorf("FAILED: close: %v", err)
+	}
+}
+
+type mockQueueDB struct {
+	*mocks.MockDB
+	*mocks.MockRescanQueue
+}
+
+func TestClientRescans(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockQueue := mocks.NewMockRescanQueue(mockCtrl)
+	server := httptest.NewServer(&Server{DB: mockQueueDB{mocks.NewMockDB(mockCtrl), mockQueue}})
+	defer server.Close()
+
+	client := &Client{URL: server.URL, ID: "worker-1"}
+	rescan := types.Rescan{Start: 1 << 24, Prefix: 24, Priority: 5, Deadline: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	mockQueue.EXPECT().EnqueueRescan(rescan).Return(int64(3), nil).Times(1)
+	if id, err := client.EnqueueRescan(rescan); err != nil || id != 3 {
+		t.Errorf("FAILED: enqueue: %d, %v", id, err)
+	}
+
+	mockQueue.EXPECT().EnqueueRescan(rescan).Return(int64(0), errors.New("some error")).Times(1)
+	if _, err := client.EnqueueRescan(rescan); err == nil {
+		t.Errorf("FAILED: enqueue error expected")
+	}
+
+	if _, err := client.EnqueueRescan(types.Rescan{Prefix: 33}); err == nil {
+		t.Errorf("FAILED: enqueue of wrong prefix should fail")
+	}
+
+	// start is masked to prefix
+	unaligned := rescan
+	unaligned.Start += 5
+	mockQueue.EXPECT().EnqueueRescan(rescan).Return(int64(4), nil).Times(1)
+	if id, err := client.EnqueueRescan(unaligned); err != nil || id != 4 {
+		t.Errorf("FAILED: enqueue of unaligned start: %d, %v", id, err)
+	}
+
+	rescan.ID = 3
+	mockQueue.EXPECT().NextRescan().Return(rescan, true, nil).Times(1)
+	if actual, ok, err := client.NextRescan(); err != nil || !ok || actual != rescan {
+		t.Errorf("FAILED: next: %+v, %v, %v", actual, ok, err)
+	}
+
+	mockQueue.EXPECT().NextRescan().Return(types.Rescan{}, false, nil).Times(1)
+	if _, ok, err := client.NextRescan(); err != nil || ok {
+		t.Errorf("FAILED: queue should be empty: %v, %v", ok, err)
+	}
+
+	mockQueue.EXPECT().FinishRescan(int64(3)).Return(nil).Times(1)
+	if err := client.FinishRescan(3); err != nil {
+		t.Errorf("FAILED: finish: %v", err)
+	}
+
+	mockQueue.EXPECT().ReleaseRescan(int64(3)).Return(nil).Times(1)
+	if err := client.ReleaseRescan(3); err != nil {
+		t.Errorf("FAILED: release: %v", err)
+	}
+
+	mockQueue.EXPECT().GetRescans().Return([]types.Rescan{rescan}, nil).Times(1)
+	if rescans, err := client.GetRescans(); err != nil || len(rescans) != 1 || rescans[0] != rescan {
+		t.Errorf("FAILED: list: %+v, %v", rescans, err)
+	}
+}
+
+func TestClientRelease(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockDB := mocks.NewMockDB(mockCtrl)
+	server := httptest.NewServer(&Server{DB: mockDB, LeaseTimeout: time.Minute, Vantages: 2})
+	defer server.Close()
+
+	mockDB.EXPECT().UnitSize().Return(uint32(1 << 16)).AnyTimes()
+	mockDB.EXPECT().GetOldestIP().Return(uint32(0), nil).AnyTimes()
+	a := &Client{URL: server.URL, Vantage: "eu"}
+	b := &Client{URL: server.URL, Vantage: "eu"}
+	c := &Client{URL: server.URL, Vantage: "us"}
+	for _, client := range []*Client{a, b, c} {
+		if err := client.Open(); err != nil {
+			t.Fatalf("Cannot register: %v", err)
+		}
+	}
+
+	if ip, err := a.GetOldestIP(); err != nil || ip != 0 {
+		t.Fatalf("FAILED: work: %d, %v", ip, err)
+	}
+	if err := a.Release(100); err != nil {
+		t.Errorf("FAILED: release: %v", err)
+	}
+
+	// rest of the range is resumed by worker of the same vantage point only
+	if ip, err := c.GetOldestIP(); err != nil || ip != 0 {
+		t.Errorf("FAILED: other vantage point should scan range from start: %d, %v", ip, err)
+	}
+	if ip, err := b.GetOldestIP(); err != nil || ip != 100 {
+		t.Errorf("FAILED: range should be resumed: %d, %v", ip, err)
+	}
+
+	// fully scanned range is neither resumed nor joined again while other vantage point scans it
+	if err := b.Release(1 << 16); err != nil {
+		t.Errorf("FAILED: release: %v", err)
+	}
+	if ip, err := a.GetOldestIP(); err != nil || ip != 1<<16 {
+		t.Errorf("FAILED: next range should be leased: %d, %v", ip, err)
+	}
+}
+
+type mockBatchDB struct {
+	*mocks.MockDB
+	*mocks.MockBatchStore
+}
+
+func TestClientSaveBatch(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockDB := mocks.NewMockDB(mockCtrl)
+	mockStore := mocks.NewMockBatchStore(mockCtrl)
+	server := httptest.NewServer(&Server{DB: mockBatchDB{mockDB, mockStore}})
+	defer server.Close()
+	client := &Client{URL: server.URL, ID: "worker-1"}
+
+	batch := types.Batch{
+		ID:      types.BatchID{Worker: "worker-1", Started: time.Date(2030, 1, 1, 0, 0, 0, 42, time.UTC), Seq: 7},
+		Results: types.Tasks{{IP: 1, Ping: true}},
+	}
+	for _, dup := range []bool{false, true} {
+		dup := dup
+		mockStore.EXPECT().SaveBatch(gomock.Any()).DoAndReturn(func(b types.Batch) (bool, error) {
+			if b.ID.String() != batch.ID.String() || len(b.Results) != 1 {
+				t.Errorf("FAILED: wrong batch %+v", b)
+			}
+			return dup, nil
+		}).Times(1)
+		if actual, err := client.SaveBatch(batch); err != nil || actual != dup {
+			t.Errorf("FAILED: save batch: %v (expected duplicate %v), %v", actual, dup, err)
+		}
+	}
+
+	mockStore.EXPECT().SaveBatch(gomock.Any()).Return(false, errors.New("some error")).Times(1)
+	if _, err := client.SaveBatch(batch); err == nil {
+		t.Errorf("FAILED: save batch error expected")
+	}
+
+	// batch is saved without deduplication if database does not support it
+	plain := httptest.NewServer(&Server{DB: mockDB})
+	defer plain.Close()
+	client.URL = plain.URL
+	mockDB.EXPECT().Save(batch.Results).Return(nil).Times(1)
+	if dup, err := client.SaveBatch(batch); err != nil || dup {
+		t.Errorf("FAILED: save batch: %v, %v", dup, err)
+	}
+}
@@ -0,0 +1,416 @@
+package coordinator
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nanorobocop/worldping/db"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+// Server hands out work units (leases) to workers and saves submitted results.
+// Lease expires if worker does not submit results for LeaseTimeout.
+// Worker is reported stale if it does not send heartbeats for StaleAfter.
+// Every range is scanned by up to Vantages workers with distinct vantage points (1 if not set).
+type Server struct {
+	DB           db.DB
+	LeaseTimeout time.Duration
+	StaleAfter   time.Duration
+	Vantages     int
+
+	mux         *http.ServeMux
+	once        sync.Once
+	mtx         sync.Mutex
+	leases      map[string]*Lease  // by worker ID
+	workers     map[string]*Worker // by worker ID
+	checkpoints map[checkpoint]uint32
+	done        map[uint32]map[string]bool // vantage points finished range still leased to others, by range start
+}
+
+// checkpoint identifies range released before it was fully scanned by vantage point
+type checkpoint struct {
+	start   uint32
+	vantage string
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.once.Do(func() {
+		s.leases = make(map[string]*Lease)
+		s.workers = make(map[string]*Worker)
+		s.checkpoints = make(map[checkpoint]uint32)
+		s.mux = http.NewServeMux()
+		s.mux.HandleFunc(PathPing, s.handlePing)
+		s.mux.HandleFunc(PathRegister, s.handleRegister)
+		s.mux.HandleFunc(PathWork, s.handleWork)
+		s.mux.HandleFunc(PathResults, s.handleResults)
+		s.mux.HandleFunc(PathMaxIP, s.handleMaxIP)
+		s.mux.HandleFunc(PathHeartbeat, s.handleHeartbeat)
+		s.mux.HandleFunc(PathWorkers, s.handleWorkers)
+		s.mux.HandleFunc(PathRelease, s.handleRelease)
+		s.mux.HandleFunc(PathRescans, s.handleRescans)
+		s.mux.HandleFunc(PathRescanNext, s.handleRescanNext)
+		s.mux.HandleFunc(PathRescanDone, s.handleRescanDone)
+		s.mux.HandleFunc(PathRescanRelease, s.handleRescanRelease)
+	})
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handlePing(w http.ResponseWriter, r *http.Request) {
+	if err := s.DB.Ping(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var req RegisterRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if req.ID == "" {
+		id, err := newWorkerID()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		req.ID = id
+	}
+
+	s.mtx.Lock()
+	now := time.Now()
+	s.workers[req.ID] = &Worker{
+		ID:           req.ID,
+		Vantage:      req.Vantage,
+		Hostname:     req.Hostname,
+		Version:      req.Version,
+		Capabilities: req.Capabilities,
+		Registered:   now,
+		LastSeen:     now,
+	}
+	s.mtx.Unlock()
+
+	encode(w, RegisterResponse{ID: req.ID, UnitSize: s.DB.UnitSize()})
+}
+
+// newWorkerID returns random worker ID, so IDs handed out before coordinator restart are not reused
+func newWorkerID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "worker-" + hex.EncodeToString(b), nil
+}
+
+// handleHeartbeat updates worker stats.
+// Workers registered before coordinator restart are added without details.
+func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var req HeartbeatRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	worker, ok := s.workers[req.ID]
+	if !ok {
+		worker = &Worker{ID: req.ID, Registered: now}
+		s.workers[req.ID] = worker
+	}
+	worker.LastSeen = now
+	worker.Rate = req.Rate
+	worker.Probed = req.Probed
+	worker.Progress = req.Progress
+}
+
+func (s *Server) handleWorkers(w http.ResponseWriter, r *http.Request) {
+	encode(w, s.fleet(time.Now()))
+}
+
+// fleet returns registered workers sorted by ID
+func (s *Server) fleet(now time.Time) []Worker {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	workers := make([]Worker, 0, len(s.workers))
+	for id, worker := range s.workers {
+		wr := *worker
+		wr.Stale = now.Sub(wr.LastSeen) > s.StaleAfter
+		if l, ok := s.leases[id]; ok && now.Before(l.Expires) {
+			lease := *l
+			wr.Lease = &lease
+		}
+		workers = append(workers, wr)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].ID < workers[j].ID })
+	return workers
+}
+
+func (s *Server) handleWork(w http.ResponseWriter, r *http.Request) {
+	var req WorkRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	start, err := s.DB.GetOldestIP()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	encode(w, s.lease(req.ID, req.Vantage, start, s.DB.UnitSize(), time.Now()))
+}
+
+// lease hands out range to worker replacing its previous lease, which is finished then.
+// Range released by worker of the same vantage point before it was fully scanned is resumed first.
+// Then range scanned by fewer than Vantages vantage points (other than worker's one, and not finished
+// by it already) is joined, so all vantage points see the range at about the same time.
+// Otherwise range starting from start (oldest one) is picked up. It could be leased already
+// (its results are not saved yet), then next available range is picked up.
+func (s *Server) lease(id, vantage string, start, size uint32, now time.Time) Lease {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	vantages := s.Vantages
+	if vantages < 1 {
+		vantages = 1
+	}
+
+	if prev, ok := s.leases[id]; ok {
+		s.finish(prev)
+		delete(s.leases, id)
+	}
+	leased := make(map[uint32]int) // amount of leases by range start
+	ours := make(map[uint32]bool)  // ranges leased to or finished by the same vantage point
+	for worker, l := range s.leases {
+		if now.After(l.Expires) {
+			delete(s.leases, worker)
+			continue
+		}
+		leased[l.Start]++
+		if l.Vantage == vantage || s.done[l.Start][vantage] {
+			ours[l.Start] = true
+		}
+	}
+	// range nobody scans is not joined any more, so vantage points finished it are forgotten
+	for st := range s.done {
+		if leased[st] == 0 {
+			delete(s.done, st)
+		}
+	}
+	available := func(start uint32) bool {
+		return leased[start] < vantages && !ours[start]
+	}
+
+	resumed, joined := false, false
+	for cp := range s.checkpoints {
+		if cp.vantage == vantage && available(cp.start) && (!resumed || cp.start < start) {
+			start, resumed = cp.start, true
+		}
+	}
+	for st := range leased {
+		if !resumed && available(st) && (!joined || st < start) {
+			start, joined = st, true
+		}
+	}
+	for i := uint64(0); !resumed && !joined && i < 1<<32/uint64(size) && !available(start); i++ {
+		start += size
+	}
+
+	next := start
+	if cp, ok := s.checkpoints[checkpoint{start, vantage}]; ok {
+		next = cp
+		delete(s.checkpoints, checkpoint{start, vantage})
+	}
+	l := &Lease{Start: start, End: start + size - 1, Next: next, Vantage: vantage, Expires: now.Add(s.LeaseTimeout)}
+	s.leases[id] = l
+	return *l
+}
+
+// finish records that vantage point of lease scanned its range, so it does not join the range again
+func (s *Server) finish(l *Lease) {
+	if s.done == nil {
+		s.done = make(map[uint32]map[string]bool)
+	}
+	if s.done[l.Start] == nil {
+		s.done[l.Start] = make(map[string]bool)
+	}
+	s.done[l.Start][l.Vantage] = true
+}
+
+// handleRelease removes lease of worker.
+// Unless range is scanned completely, its rest is handed out to next worker of the same vantage point.
+func (s *Server) handleRelease(w http.ResponseWriter, r *http.Request) {
+	var req ReleaseRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	l, ok := s.leases[req.ID]
+	if !ok {
+		return
+	}
+	delete(s.leases, req.ID)
+	if req.Next > l.Start && req.Next <= l.End {
+		s.checkpoints[checkpoint{l.Start, l.Vantage}] = req.Next
+	} else {
+		s.finish(l)
+	}
+}
+
+// handleResults saves results (identified batch only once, if database supports it) and renews lease
+func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
+	var req ResultsRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	var resp ResultsResponse
+	var err error
+	if store, ok := s.DB.(db.BatchStore); ok && req.Batch != nil && !req.Batch.IsZero() {
+		resp.Duplicate, err = store.SaveBatch(types.Batch{ID: *req.Batch, Results: req.Results})
+	} else {
+		err = s.DB.Save(req.Results)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.mtx.Lock()
+	if l, ok := s.leases[req.ID]; ok {
+		l.Expires = time.Now().Add(s.LeaseTimeout)
+	}
+	s.mtx.Unlock()
+	encode(w, resp)
+}
+
+func (s *Server) handleMaxIP(w http.ResponseWriter, r *http.Request) {
+	ip, err := s.DB.GetMaxIP()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encode(w, MaxIPResponse{IP: ip})
+}
+
+// rescanQueue returns queue if database supports it, otherwise replies with error
+func (s *Server) rescanQueue(w http.ResponseWriter) (db.RescanQueue, bool) {
+	queue, ok := s.DB.(db.RescanQueue)
+	if !ok {
+		http.Error(w, "rescan queue is not supported by database", http.StatusNotImplemented)
+	}
+	return queue, ok
+}
+
+// handleRescans lists queue on GET, enqueues prefix on POST
+func (s *Server) handleRescans(w http.ResponseWriter, r *http.Request) {
+	queue, ok := s.rescanQueue(w)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		rescans, err := queue.GetRescans()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		encode(w, rescans)
+		return
+	}
+
+	var req types.Rescan
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Prefix > 32 {
+		http.Error(w, fmt.Sprintf("wrong prefix length %d", req.Prefix), http.StatusBadRequest)
+		return
+	}
+	// host bits are dropped, so worker scans the prefix itself
+	req.Start &= ^uint32(0) << (32 - req.Prefix)
+	id, err := queue.EnqueueRescan(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encode(w, EnqueueResponse{ID: id})
+}
+
+func (s *Server) handleRescanNext(w http.ResponseWriter, r *http.Request) {
+	var req WorkRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	queue, ok := s.rescanQueue(w)
+	if !ok {
+		return
+	}
+
+	rescan, ok, err := queue.NextRescan()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encode(w, NextRescanResponse{Rescan: rescan, OK: ok})
+}
+
+func (s *Server) handleRescanDone(w http.ResponseWriter, r *http.Request) {
+	var req RescanDoneRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	queue, ok := s.rescanQueue(w)
+	if !ok {
+		return
+	}
+
+	if err := queue.FinishRescan(req.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) handleRescanRelease(w http.ResponseWriter, r *http.Request) {
+	var req RescanDoneRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	queue, ok := s.rescanQueue(w)
+	if !ok {
+		return
+	}
+
+	if err := queue.ReleaseRescan(req.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// decode reads JSON request, replies with error and returns false if failed
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func encode(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,180 @@
+package coordinator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/nanorobocop/worldping/mocks"
+)
+
+func TestLease(t *testing.T) {
+	s := &Server{LeaseTimeout: time.Minute, leases: make(map[string]*Lease)}
+	now := time.Now()
+
+	steps := []struct {
+		id    string
+		start uint32
+		size  uint32
+		now   time.Time
+		exp   uint32
+	}{
+		{id: "a", start: 0, size: 1 << 24, now: now, exp: 0},
+		// oldest range is leased to "a" already
+		{id: "b", start: 0, size: 1 << 24, now: now, exp: 1 << 24},
+		{id: "c", start: 0, size: 1 << 24, now: now, exp: 2 << 24},
+		// "a" takes next range, its previous lease is released
+		{id: "a", start: 5 << 24, size: 1 << 24, now: now, exp: 5 << 24},
+		{id: "d", start: 0, size: 1 << 24, now: now, exp: 0},
+		// wraps around the end of address space
+		{id: "e", start: 255 << 24, size: 1 << 24, now: now, exp: 255 << 24},
+		{id: "f", start: 255 << 24, size: 1 << 24, now: now, exp: 3 << 24},
+		// all leases expired
+		{id: "g", start: 0, size: 1 << 24, now: now.Add(2 * time.Minute), exp: 0},
+		// smaller units
+		{id: "h", start: 0, size: 1 << 16, now: now.Add(2 * time.Minute), exp: 1 << 16},
+		{id: "i", start: 1 << 16, size: 1 << 16, now: now.Add(2 * time.Minute), exp: 2 << 16},
+	}
+
+	for i, step := range steps {
+		l := s.lease(step.id, "", step.start, step.size, step.now)
+		if l.Start != step.exp || l.End != step.exp+step.size-1 {
+			t.Errorf("Step %d FAILED: %d:%d (actual) != %d (expected)", i, l.Start, l.End, step.exp)
+		}
+		if !l.Expires.Equal(step.now.Add(time.Minute)) {
+			t.Errorf("Step %d FAILED: wrong expiration %v", i, l.Expires)
+		}
+	}
+}
+
+func TestLeaseVantages(t *testing.T) {
+	s := &Server{LeaseTimeout: time.Minute, Vantages: 2, leases: make(map[string]*Lease)}
+	now := time.Now()
+
+	steps := []struct {
+		id      string
+		vantage string
+		start   uint32
+		exp     uint32
+	}{
+		{id: "a", vantage: "eu", start: 0, exp: 0},
+		// second vantage point joins the range
+		{id: "b", vantage: "us", start: 5 << 24, exp: 0},
+		// range is scanned by two vantage points already
+		{id: "c", vantage: "asia", start: 0, exp: 1 << 24},
+		// the same vantage point does not scan range twice
+		{id: "d", vantage: "asia", start: 0, exp: 2 << 24},
+		{id: "e", vantage: "eu", start: 0, exp: 1 << 24},
+	}
+
+	for i, step := range steps {
+		l := s.lease(step.id, step.vantage, step.start, 1<<24, now)
+		if l.Start != step.exp || l.Vantage != step.vantage {
+			t.Errorf("Step %d FAILED: %d %q (actual) != %d %q (expected)", i, l.Start, l.Vantage, step.exp, step.vantage)
+		}
+	}
+
+	// vantage point finished range does not join it again while other one still scans it
+	s = &Server{LeaseTimeout: time.Minute, Vantages: 2, leases: make(map[string]*Lease)}
+	for i, step := range []struct {
+		id, vantage string
+		start, exp  uint32
+	}{
+		{id: "a", vantage: "eu", start: 0, exp: 0},
+		{id: "b", vantage: "us", start: 0, exp: 0},
+		{id: "a", vantage: "eu", start: 1 << 24, exp: 1 << 24},
+		// the same applies to other worker of vantage point
+		{id: "c", vantage: "eu", start: 0, exp: 2 << 24},
+		// range is forgotten when nobody scans it
+		{id: "b", vantage: "us", start: 3 << 24, exp: 1 << 24},
+		{id: "d", vantage: "eu", start: 0, exp: 0},
+	} {
+		l := s.lease(step.id, step.vantage, step.start, 1<<24, now)
+		if l.Start != step.exp || l.Vantage != step.vantage {
+			t.Errorf("Finished step %d FAILED: %d %q (actual) != %d %q (expected)", i, l.Start, l.Vantage, step.exp, step.vantage)
+		}
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockDB := mocks.NewMockDB(mockCtrl)
+	mockDB.EXPECT().UnitSize().Return(uint32(1 << 24)).AnyTimes()
+	s := &Server{DB: mockDB}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{method: http.MethodGet, path: PathRegister, body: "", status: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, path: PathRegister, body: "{", status: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/unknown", body: "{}", status: http.StatusNotFound},
+		{method: http.MethodPost, path: PathRegister, body: "{}", status: http.StatusOK},
+		// mock database does not implement rescan queue
+		{method: http.MethodGet, path: PathRescans, body: "", status: http.StatusNotImplemented},
+		{method: http.MethodPost, path: PathRescanNext, body: "{}", status: http.StatusNotImplemented},
+	}
+
+	for i, test := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(test.method, test.path, strings.NewReader(test.body)))
+		if w.Code != test.status {
+			t.Errorf("Test %d FAILED: %d (actual) != %d (expected)", i, w.Code, test.status)
+		}
+	}
+}
+
+func TestFleet(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockDB := mocks.NewMockDB(mockCtrl)
+	mockDB.EXPECT().UnitSize().Return(uint32(1 << 24)).AnyTimes()
+	s := &Server{DB: mockDB, LeaseTimeout: time.Minute, StaleAfter: time.Minute}
+	requests := []struct {
+		path string
+		body string
+	}{
+		{path: PathRegister, body: `{"id":"a","hostname":"host-a","version":"1.0","capabilities":["echo"]}`},
+		{path: PathRegister, body: `{"id":"b","hostname":"host-b"}`},
+		{path: PathHeartbeat, body: `{"id":"a","rate":10.5,"probed":100,"progress":16777316}`},
+		// worker registered before coordinator restart
+		{path: PathHeartbeat, body: `{"id":"c","rate":1}`},
+	}
+	for i, r := range requests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, r.path, strings.NewReader(r.body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %d FAILED: status %d", i, w.Code)
+		}
+	}
+	s.lease("a", "", 1<<24, 1<<24, time.Now())
+
+	workers := s.fleet(time.Now())
+	if len(workers) != 3 || workers[0].ID != "a" || workers[1].ID != "b" || workers[2].ID != "c" {
+		t.Fatalf("FAILED: wrong workers %+v", workers)
+	}
+	a := workers[0]
+	if a.Hostname != "host-a" || a.Version != "1.0" || len(a.Capabilities) != 1 || a.Rate != 10.5 || a.Probed != 100 || a.Progress != 1<<24+100 {
+		t.Errorf("FAILED: wrong worker %+v", a)
+	}
+	if a.Lease == nil || a.Lease.Start != 1<<24 || workers[1].Lease != nil {
+		t.Errorf("FAILED: wrong leases %+v, %+v", a.Lease, workers[1].Lease)
+	}
+	if a.Stale || workers[1].Stale {
+		t.Errorf("FAILED: fresh workers reported stale")
+	}
+
+	for _, wr := range s.fleet(time.Now().Add(2 * time.Minute)) {
+		if !wr.Stale || wr.Lease != nil {
+			t.Errorf("FAILED: worker %s should be stale without lease", wr.ID)
+		}
+	}
+}
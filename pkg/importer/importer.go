@@ -0,0 +1,297 @@
+// Package importer reads output of other scanners (zmap, masscan) and snapshots as worldping results.
+package importer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/snapshot"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+// Formats of results
+const (
+	ZMap          = "zmap"           // CSV with header (-O csv) or list of addresses (default output)
+	MasscanJSON   = "masscan-json"   // -oJ (JSON array) or -oD (NDJSON)
+	MasscanList   = "masscan-list"   // -oL
+	MasscanBinary = "masscan-binary" // -oB
+	Snapshot      = "snapshot"       // snapshot of worldping results
+)
+
+// Sources of imported results
+const (
+	SourceZMap    = "zmap"
+	SourceMasscan = "masscan"
+)
+
+// zmapTimeFormat is format of zmap timestamp_str field
+const zmapTimeFormat = "2006-01-02T15:04:05.000-0700"
+
+// Read parses output of format from r and passes results to f one by one, stops at first error.
+// Results have Source set to scanner (empty for snapshots) and Time to time of probe if output has it.
+// Scanners report every responsive port separately, so the same address could be passed several times.
+func Read(format string, r io.Reader, f func(types.Task) error) error {
+	switch format {
+	case ZMap:
+		return readZMap(r, f)
+	case MasscanJSON:
+		return readMasscanJSON(r, f)
+	case MasscanList:
+		return readMasscanList(r, f)
+	case MasscanBinary:
+		return readMasscanBinary(r, f)
+	case Snapshot:
+		s, err := snapshot.Read(r)
+		if err != nil {
+			return err
+		}
+		return s.Results(f)
+	default:
+		return fmt.Errorf("unknown format %q (should be %s, %s, %s, %s or %s)", format, ZMap, MasscanJSON, MasscanList, MasscanBinary, Snapshot)
+	}
+}
+
+// readZMap reads CSV with header containing saddr field, or list of addresses if there is no header.
+// Fields success (responsive if missing), classification (stored as probe) and timestamp_str or timestamp_ts are used.
+func readZMap(r io.Reader, f func(types.Task) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	fields := map[string]int{"saddr": 0}
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if line == 1 && record[0] != "" && net.ParseIP(record[0]) == nil {
+			fields = map[string]int{}
+			for i, name := range record {
+				fields[strings.TrimSpace(name)] = i
+			}
+			if _, ok := fields["saddr"]; !ok {
+				return fmt.Errorf("line 1: header has no saddr field")
+			}
+			continue
+		}
+		get := func(name string) string {
+			if i, ok := fields[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		t := types.Task{Ping: true, Source: SourceZMap, Probe: get("classification")}
+		if t.IP, err = parseIP(get("saddr")); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if s := get("success"); s != "" {
+			if t.Ping, err = strconv.ParseBool(s); err != nil {
+				return fmt.Errorf("line %d: wrong success %q", line, s)
+			}
+		}
+		if s := get("timestamp_str"); s != "" {
+			if t.Time, err = time.Parse(zmapTimeFormat, s); err != nil {
+				return fmt.Errorf("line %d: wrong timestamp_str %q", line, s)
+			}
+		} else if s := get("timestamp_ts"); s != "" {
+			if t.Time, err = parseUnix(s); err != nil {
+				return fmt.Errorf("line %d: wrong timestamp_ts %q", line, s)
+			}
+		}
+		if err = f(t); err != nil {
+			return err
+		}
+	}
+}
+
+// masscanRecord is result of masscan JSON output
+type masscanRecord struct {
+	IP        string `json:"ip"`
+	Timestamp string `json:"timestamp"`
+	Ports     []struct {
+		Port   int    `json:"port"`
+		Proto  string `json:"proto"`
+		Status string `json:"status"`
+	} `json:"ports"`
+}
+
+// readMasscanJSON reads JSON array with object per line (as masscan writes it) or NDJSON
+func readMasscanJSON(r io.Reader, f func(types.Task) error) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "["), "]")
+		text = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(text), ","), ",")
+		// old versions end array with {finished: 1}
+		if text == "" || strings.HasPrefix(text, "{finished") {
+			continue
+		}
+		var rec masscanRecord
+		if err := json.Unmarshal([]byte(text), &rec); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		ip, err := parseIP(rec.IP)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		when, err := parseUnix(rec.Timestamp)
+		if err != nil {
+			return fmt.Errorf("line %d: wrong timestamp %q", line, rec.Timestamp)
+		}
+		for _, p := range rec.Ports {
+			if err = f(masscanTask(ip, p.Status, p.Proto, p.Port, when)); err != nil {
+				return err
+			}
+		}
+	}
+	return s.Err()
+}
+
+// readMasscanList reads lines "STATUS PROTO PORT IP TIMESTAMP", banners and comments are skipped
+func readMasscanList(r io.Reader, f func(types.Task) error) error {
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || fields[0] == "banner" {
+			continue
+		}
+		if len(fields) < 5 {
+			return fmt.Errorf("line %d: expected STATUS PROTO PORT IP TIMESTAMP", line)
+		}
+		port, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf("line %d: wrong port %q", line, fields[2])
+		}
+		ip, err := parseIP(fields[3])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		when, err := parseUnix(fields[4])
+		if err != nil {
+			return fmt.Errorf("line %d: wrong timestamp %q", line, fields[4])
+		}
+		if err = f(masscanTask(ip, fields[0], fields[1], port, when)); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// Types of masscan binary records (see masscan in-binary.c)
+const (
+	masscanOpen    = 1 // status without protocol (TCP)
+	masscanClosed  = 2 // status without protocol (TCP)
+	masscanOpen2   = 6 // status with protocol
+	masscanClosed2 = 7 // status with protocol
+)
+
+// masscanProtos are names of IP protocols as in masscan list output
+var masscanProtos = map[byte]string{1: "icmp", 6: "tcp", 17: "udp", 132: "sctp"}
+
+// readMasscanBinary reads records "TYPE LENGTH DATA" of masscan binary output.
+// Length is one byte, or two bytes of 7 bits each if the high bit of the first is set.
+// Status data is timestamp (4 bytes), IPv4 address (4), port (2), reason (1) and TTL (1), newer types
+// have IP protocol (1) between address and port, all big-endian. File starts and ends with "masscan/1.1" padded to 99 bytes, which is record
+// of type 'm' and length 'a'; it is skipped together with banners, ARP and IPv6 records.
+func readMasscanBinary(r io.Reader, f func(types.Task) error) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(10); err != nil || string(magic) != "masscan/1." {
+		return fmt.Errorf("not masscan binary output")
+	}
+	buf := make([]byte, 1<<14)
+	for n := 1; ; n++ {
+		typ, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		b, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("record %d: truncated length", n)
+		}
+		length := int(b)
+		if b&0x80 != 0 {
+			if b, err = br.ReadByte(); err != nil {
+				return fmt.Errorf("record %d: truncated length", n)
+			}
+			length = int(length&0x7f)<<7 | int(b)
+		}
+		data := buf[:length]
+		if _, err = io.ReadFull(br, data); err != nil {
+			return fmt.Errorf("record %d: truncated data", n)
+		}
+
+		var status string
+		switch typ {
+		case masscanOpen, masscanOpen2:
+			status = "open"
+		case masscanClosed, masscanClosed2:
+			status = "closed"
+		default:
+			continue
+		}
+		withProto := typ == masscanOpen2 || typ == masscanClosed2
+		if withProto && length < 13 || length < 12 {
+			return fmt.Errorf("record %d: short status", n)
+		}
+		when := time.Unix(int64(binary.BigEndian.Uint32(data)), 0)
+		ip := binary.BigEndian.Uint32(data[4:])
+		proto, port := "tcp", int(binary.BigEndian.Uint16(data[8:]))
+		if withProto {
+			if proto = masscanProtos[data[8]]; proto == "" {
+				proto = strconv.Itoa(int(data[8]))
+			}
+			port = int(binary.BigEndian.Uint16(data[9:]))
+		}
+		if err = f(masscanTask(ip, status, proto, port, when)); err != nil {
+			return err
+		}
+	}
+}
+
+// masscanTask returns result of port, probe is named PROTO:PORT (echo for ICMP).
+// Closed port is reply of host too (RST, as to tcp-ack probe), so host is responsive.
+func masscanTask(ip uint32, status, proto string, port int, when time.Time) types.Task {
+	t := types.Task{IP: ip, Ping: status == "open" || status == "closed", Source: SourceMasscan, Time: when}
+	if t.Ping {
+		t.Probe = fmt.Sprintf("%s:%d", proto, port)
+		if proto == "icmp" {
+			t.Probe = "echo"
+		}
+	}
+	return t
+}
+
+func parseIP(s string) (uint32, error) {
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return 0, fmt.Errorf("wrong IPv4 address %q", s)
+	}
+	return binary.BigEndian.Uint32(ip), nil
+}
+
+// parseUnix parses unix time in seconds, zero time if it is empty
+func parseUnix(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0), nil
+}
@@ -0,0 +1,143 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func readAll(format, input string) (results []types.Task, err error) {
+	err = Read(format, strings.NewReader(input), func(t types.Task) error {
+		results = append(results, t)
+		return nil
+	})
+	return results, err
+}
+
+// masscanBinary returns masscan binary output with header and trailer around records
+func masscanBinary(records ...string) string {
+	header := make([]byte, 99)
+	copy(header, "masscan/1.1\ns:1609459200\n")
+	return string(header) + strings.Join(records, "") + string(header[:11]) + strings.Repeat("\x00", 88)
+}
+
+func TestRead(t *testing.T) {
+	when := time.Unix(1609459200, 0)
+	tests := []struct {
+		name, format, input string
+		expected            []types.Task
+	}{
+		{
+			name:   "zmap list",
+			format: ZMap,
+			input:  "192.0.2.1\n192.0.2.2\n",
+			expected: []types.Task{
+				{IP: 3221225985, Ping: true, Source: SourceZMap},
+				{IP: 3221225986, Ping: true, Source: SourceZMap},
+			},
+		},
+		{
+			name:   "zmap csv",
+			format: ZMap,
+			input: "saddr,classification,success,timestamp_str\n" +
+				"192.0.2.1,echoreply,1,2021-01-01T00:00:00.000+0000\n" +
+				"192.0.2.2,unreach,0,2021-01-01T00:00:00.000+0000\n",
+			expected: []types.Task{
+				{IP: 3221225985, Ping: true, Probe: "echoreply", Source: SourceZMap, Time: when},
+				{IP: 3221225986, Probe: "unreach", Source: SourceZMap, Time: when},
+			},
+		},
+		{
+			name:   "zmap csv unix time",
+			format: ZMap,
+			input:  "timestamp_ts,saddr\n1609459200,192.0.2.1\n",
+			expected: []types.Task{
+				{IP: 3221225985, Ping: true, Source: SourceZMap, Time: when},
+			},
+		},
+		{
+			name:   "masscan json array",
+			format: MasscanJSON,
+			input: "[\n" +
+				`{   "ip": "192.0.2.1",   "timestamp": "1609459200", "ports": [ {"port": 80, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 54} ] }` + ",\n" +
+				`{   "ip": "192.0.2.2",   "timestamp": "1609459200", "ports": [ {"port": 0, "proto": "icmp", "status": "open", "reason": "none", "ttl": 54} ] }` + "\n" +
+				"]\n",
+			expected: []types.Task{
+				{IP: 3221225985, Ping: true, Probe: "tcp:80", Source: SourceMasscan, Time: when},
+				{IP: 3221225986, Ping: true, Probe: "echo", Source: SourceMasscan, Time: when},
+			},
+		},
+		{
+			name:   "masscan ndjson",
+			format: MasscanJSON,
+			input:  `{"ip":"192.0.2.1","timestamp":"1609459200","ports":[{"port":443,"proto":"tcp","status":"closed"}]}` + "\n",
+			expected: []types.Task{
+				{IP: 3221225985, Ping: true, Probe: "tcp:443", Source: SourceMasscan, Time: when},
+			},
+		},
+		{
+			name:   "masscan list",
+			format: MasscanList,
+			input:  "#masscan\nopen tcp 80 192.0.2.1 1609459200\nbanner tcp 80 192.0.2.1 1609459200 http nginx\nopen udp 53 192.0.2.2 1609459200\n# end\n",
+			expected: []types.Task{
+				{IP: 3221225985, Ping: true, Probe: "tcp:80", Source: SourceMasscan, Time: when},
+				{IP: 3221225986, Ping: true, Probe: "udp:53", Source: SourceMasscan, Time: when},
+			},
+		},
+		{
+			name:   "masscan binary",
+			format: MasscanBinary,
+			input: masscanBinary(
+				"\x01\x0c\x5f\xee\x66\x00\xc0\x00\x02\x01\x00\x50\x12\x36",
+				// banner of two-byte length
+				"\x05\x81\x00"+strings.Repeat("b", 128),
+				// timestamp, address, protocol, port, reason, TTL
+				"\x06\x0d\x5f\xee\x66\x00\xc0\x00\x02\x02\x06\x01\xbb\x12\x40",
+				"\x06\x0d\x5f\xee\x66\x00\xc0\x00\x02\x03\x01\x00\x00\x00\x40",
+				"\x07\x0d\x5f\xee\x66\x00\xc0\x00\x02\x04\x11\x00\x35\x00\x40",
+			),
+			expected: []types.Task{
+				{IP: 3221225985, Ping: true, Probe: "tcp:80", Source: SourceMasscan, Time: when},
+				{IP: 3221225986, Ping: true, Probe: "tcp:443", Source: SourceMasscan, Time: when},
+				{IP: 3221225987, Ping: true, Probe: "echo", Source: SourceMasscan, Time: when},
+				{IP: 3221225988, Ping: true, Probe: "udp:53", Source: SourceMasscan, Time: when},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		actual, err := readAll(test.format, test.input)
+		if err != nil {
+			t.Errorf("%s FAILED: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s FAILED:\n%+v (actual)\n%+v (expected)", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name, format, input, expected string
+	}{
+		{"unknown format", "nmap", "", "unknown format"},
+		{"masscan binary without header", MasscanBinary, "open tcp 80 192.0.2.1 1609459200\n", "not masscan"},
+		{"masscan binary truncated", MasscanBinary, masscanBinary("\x06\x0d\x5f\xee")[:103], "record 2"},
+		{"masscan binary short status", MasscanBinary, masscanBinary("\x06\x02\x5f\xee"), "record 2"},
+		{"zmap without saddr", ZMap, "daddr,success\n192.0.2.1,1\n", "no saddr"},
+		{"zmap wrong address", ZMap, "192.0.2.1\n2001:db8::1\n", "line 2"},
+		{"masscan wrong json", MasscanJSON, "{\"ip\": \n", "line 1"},
+		{"masscan short line", MasscanList, "open tcp 80\n", "line 1"},
+	}
+
+	for _, test := range tests {
+		_, err := readAll(test.format, test.input)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s FAILED: error %v should contain %q", test.name, err, test.expected)
+		}
+	}
+}
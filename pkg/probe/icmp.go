@@ -0,0 +1,278 @@
+package probe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	protocolICMP = 1
+
+	payloadSize = 56 // same as ping(8) default, 64 bytes ICMP packet
+)
+
+// ICMPError is returned when probe elicited ICMP error message
+// (e.g. destination unreachable) from destination or intermediate router
+type ICMPError struct {
+	Type   uint8
+	Code   uint8
+	Router net.IP
+}
+
+func (e *ICMPError) Error() string {
+	return fmt.Sprintf("%v (code %d) from %v", ipv4.ICMPType(e.Type), e.Code, e.Router)
+}
+
+// reply is passed by receiver to the request waiting for it
+type reply struct {
+	t   time.Time
+	err error
+}
+
+// request is running probe. Receiver counts all replies to it,
+// only first reply (or error) is passed to the channel.
+type request struct {
+	dst     net.IP
+	ch      chan reply
+	replies int
+	foreign net.IP
+}
+
+// ICMP sends ICMP echo or timestamp requests over raw socket.
+// Identifier and sequence number together form 32-bit key
+// used to correlate replies and error messages with running requests.
+type ICMP struct {
+	// Linger is how long to wait for duplicate replies after the first one.
+	// Should be set before first probe.
+	Linger time.Duration
+
+	conn net.PacketConn
+	typ  string
+	key  uint32
+
+	requests map[uint32]*request
+	mtx      sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// NewICMP opens raw ICMP socket and starts receiver.
+// Type is either Echo or Timestamp.
+func NewICMP(bind, typ string) (*ICMP, error) {
+	if typ != Echo && typ != Timestamp {
+		return nil, fmt.Errorf("unsupported ICMP probe %q", typ)
+	}
+
+	conn, err := icmp.ListenPacket("ip4:icmp", bind)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ICMP{
+		conn:     conn,
+		typ:      typ,
+		requests: make(map[uint32]*request),
+	}
+	p.wg.Add(1)
+	go p.receiver()
+	return p, nil
+}
+
+// Name returns probe type
+func (p *ICMP) Name() string {
+	return p.typ
+}
+
+// Probe sends request and waits for reply.
+// ICMP error message related to request is returned as *ICMPError.
+// After first reply probe lingers to count duplicates.
+func (p *ICMP) Probe(dst *net.IPAddr, timeout time.Duration) (Result, error) {
+	key := atomic.AddUint32(&p.key, 1)
+	req := &request{dst: dst.IP, ch: make(chan reply, 1)}
+
+	p.mtx.Lock()
+	p.requests[key] = req
+	p.mtx.Unlock()
+
+	defer func() {
+		p.mtx.Lock()
+		delete(p.requests, key)
+		p.mtx.Unlock()
+	}()
+
+	start := time.Now()
+	var b []byte
+	var err error
+	if p.typ == Echo {
+		b, err = echoRequest(key)
+	} else {
+		b, err = timestampRequest(key, start)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	if _, err := p.conn.WriteTo(b, dst); err != nil {
+		return Result{}, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var r reply
+	select {
+	case r = <-req.ch:
+	case <-timer.C:
+		return Result{}, ErrTimeout
+	}
+	if r.err != nil {
+		return Result{}, r.err
+	}
+
+	if p.Linger > 0 {
+		time.Sleep(p.Linger)
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return Result{RTT: r.t.Sub(start), Replies: req.replies, Foreign: req.foreign}, nil
+}
+
+// Close closes socket and waits for receiver
+func (p *ICMP) Close() {
+	p.conn.Close()
+	p.wg.Wait()
+}
+
+func (p *ICMP) receiver() {
+	defer p.wg.Done()
+
+	b := make([]byte, 1500)
+	for {
+		n, from, err := p.conn.ReadFrom(b)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return // socket closed
+		}
+		p.receive(b[:n], from.(*net.IPAddr).IP, time.Now())
+	}
+}
+
+// receive parses ICMP message and passes it to the request waiting for it
+func (p *ICMP) receive(b []byte, from net.IP, t time.Time) {
+	m, err := icmp.ParseMessage(protocolICMP, b)
+	if err != nil {
+		return
+	}
+
+	var key uint32
+	r := reply{t: t}
+
+	switch m.Type {
+	case ipv4.ICMPTypeEchoReply:
+		echo, ok := m.Body.(*icmp.Echo)
+		if !ok || p.typ != Echo {
+			return
+		}
+		key = uint32(echo.ID)<<16 | uint32(echo.Seq)
+	case ipv4.ICMPTypeTimestampReply:
+		body, ok := m.Body.(*icmp.RawBody)
+		if !ok || len(body.Data) < 4 || p.typ != Timestamp {
+			return
+		}
+		key = binary.BigEndian.Uint32(body.Data[:4])
+	case ipv4.ICMPTypeDestinationUnreachable, ipv4.ICMPTypeTimeExceeded, ipv4.ICMPTypeParameterProblem:
+		var ok bool
+		if key, ok = p.quotedKey(m.Body); !ok {
+			return
+		}
+		r.err = &ICMPError{Type: uint8(m.Type.(ipv4.ICMPType)), Code: uint8(m.Code), Router: from}
+	default:
+		return
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	req := p.requests[key]
+	if req == nil {
+		return
+	}
+	if r.err == nil {
+		req.replies++
+		if !from.Equal(req.dst) && req.foreign == nil {
+			req.foreign = from
+		}
+	}
+	select {
+	case req.ch <- r:
+	default:
+	}
+}
+
+// quotedKey extracts key of our request quoted in ICMP error message.
+// Error message carries IP header and first 8 bytes of original datagram.
+func (p *ICMP) quotedKey(body icmp.MessageBody) (uint32, bool) {
+	var data []byte
+	switch body := body.(type) {
+	case *icmp.DstUnreach:
+		data = body.Data
+	case *icmp.TimeExceeded:
+		data = body.Data
+	case *icmp.ParamProb:
+		data = body.Data
+	}
+
+	hdr, err := ipv4.ParseHeader(data)
+	if err != nil || hdr.Protocol != protocolICMP || len(data) < hdr.Len+8 {
+		return 0, false
+	}
+	orig := data[hdr.Len:]
+
+	requestType := ipv4.ICMPTypeEcho
+	if p.typ == Timestamp {
+		requestType = ipv4.ICMPTypeTimestamp
+	}
+	if ipv4.ICMPType(orig[0]) != requestType {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(orig[4:8]), true
+}
+
+// echoRequest builds ICMP echo request.
+// Key is written into identifier and sequence number fields.
+func echoRequest(key uint32) ([]byte, error) {
+	m := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{
+			ID:   int(key >> 16),
+			Seq:  int(key & 0xffff),
+			Data: make([]byte, payloadSize),
+		},
+	}
+	return m.Marshal(nil)
+}
+
+// timestampRequest builds ICMP timestamp request (RFC 792).
+// Key is written into identifier and sequence number fields.
+func timestampRequest(key uint32, t time.Time) ([]byte, error) {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint32(data[0:], key)
+	binary.BigEndian.PutUint32(data[4:], uint32(t.Sub(midnight)/time.Millisecond))
+
+	m := icmp.Message{
+		Type: ipv4.ICMPTypeTimestamp,
+		Body: &icmp.RawBody{Data: data},
+	}
+	return m.Marshal(nil)
+}
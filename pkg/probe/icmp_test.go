@@ -0,0 +1,196 @@
+package probe
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func TestTimestampRequest(t *testing.T) {
+	now := time.Date(2021, 5, 1, 1, 2, 3, 4000000, time.UTC)
+
+	b, err := timestampRequest(0x01020304, now)
+	if err != nil {
+		t.Fatalf("Cannot build request: %v", err)
+	}
+
+	m, err := icmp.ParseMessage(protocolICMP, b)
+	if err != nil {
+		t.Fatalf("Cannot parse request: %v", err)
+	}
+	if m.Type != ipv4.ICMPTypeTimestamp {
+		t.Errorf("FAILED: wrong type %v", m.Type)
+	}
+	data := m.Body.(*icmp.RawBody).Data
+	if len(data) != 16 {
+		t.Fatalf("FAILED: wrong body length %d", len(data))
+	}
+	if key := binary.BigEndian.Uint32(data[0:]); key != 0x01020304 {
+		t.Errorf("FAILED: wrong key %x", key)
+	}
+	if ms := binary.BigEndian.Uint32(data[4:]); ms != 3723004 {
+		t.Errorf("FAILED: wrong originate timestamp %d", ms)
+	}
+}
+
+func TestEchoRequest(t *testing.T) {
+	b, err := echoRequest(0x01020304)
+	if err != nil {
+		t.Fatalf("Cannot build request: %v", err)
+	}
+
+	m, err := icmp.ParseMessage(protocolICMP, b)
+	if err != nil {
+		t.Fatalf("Cannot parse request: %v", err)
+	}
+	echo, ok := m.Body.(*icmp.Echo)
+	if m.Type != ipv4.ICMPTypeEcho || !ok {
+		t.Fatalf("FAILED: wrong message %+v", m)
+	}
+	if echo.ID != 0x0102 || echo.Seq != 0x0304 || len(echo.Data) != payloadSize {
+		t.Errorf("FAILED: wrong echo %+v", echo)
+	}
+}
+
+// rawReply builds message with given type and body starting with key
+func rawReply(typ ipv4.ICMPType, key uint32) []byte {
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint32(data, key)
+	b, _ := (&icmp.Message{Type: typ, Body: &icmp.RawBody{Data: data}}).Marshal(nil)
+	return b
+}
+
+// errorReply builds ICMP error message quoting original request
+func errorReply(typ ipv4.ICMPType, code int, request []byte) []byte {
+	hdr := ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen,
+		TotalLen: ipv4.HeaderLen + len(request),
+		TTL:      1,
+		Protocol: protocolICMP,
+		Src:      net.IPv4(10, 0, 0, 1),
+		Dst:      net.IPv4(10, 0, 0, 2),
+	}
+	data, _ := hdr.Marshal()
+	data = append(data, request[:8]...)
+
+	var body icmp.MessageBody = &icmp.DstUnreach{Data: data}
+	if typ == ipv4.ICMPTypeTimeExceeded {
+		body = &icmp.TimeExceeded{Data: data}
+	}
+	b, _ := (&icmp.Message{Type: typ, Code: code, Body: body}).Marshal(nil)
+	return b
+}
+
+func TestICMPReceive(t *testing.T) {
+	router := net.IPv4(192, 0, 2, 1)
+	echo42, _ := echoRequest(42)
+	echoReply42, _ := (&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 0, Seq: 42}}).Marshal(nil)
+	timestamp42, _ := timestampRequest(42, time.Now())
+
+	steps := []struct {
+		typ      string
+		packet   []byte
+		expected bool
+		err      *ICMPError
+	}{
+		{typ: Timestamp, packet: []byte{1, 2}, expected: false},
+		{typ: Timestamp, packet: rawReply(ipv4.ICMPTypeTimestamp, 42), expected: false},
+		{typ: Timestamp, packet: rawReply(ipv4.ICMPTypeTimestampReply, 43), expected: false},
+		{typ: Timestamp, packet: rawReply(ipv4.ICMPTypeTimestampReply, 42), expected: true},
+		{typ: Echo, packet: rawReply(ipv4.ICMPTypeTimestampReply, 42), expected: false},
+		{typ: Timestamp, packet: echoReply42, expected: false},
+		{typ: Echo, packet: echoReply42, expected: true},
+		{typ: Echo, packet: errorReply(ipv4.ICMPTypeDestinationUnreachable, 13, timestamp42), expected: false},
+		{
+			typ:      Echo,
+			packet:   errorReply(ipv4.ICMPTypeDestinationUnreachable, 13, echo42),
+			expected: true,
+			err:      &ICMPError{Type: 3, Code: 13, Router: router},
+		},
+		{
+			typ:      Timestamp,
+			packet:   errorReply(ipv4.ICMPTypeTimeExceeded, 0, timestamp42),
+			expected: true,
+			err:      &ICMPError{Type: 11, Code: 0, Router: router},
+		},
+	}
+
+	for i, step := range steps {
+		p := &ICMP{typ: step.typ, requests: make(map[uint32]*request)}
+		req := &request{dst: router, ch: make(chan reply, 1)}
+		p.requests[42] = req
+
+		p.receive(step.packet, router, time.Now())
+		select {
+		case r := <-req.ch:
+			if !step.expected {
+				t.Errorf("Step %d FAILED: unexpected reply delivered", i)
+			}
+			if step.err == nil && r.err != nil {
+				t.Errorf("Step %d FAILED: unexpected error %v", i, r.err)
+			}
+			if step.err != nil {
+				icmpErr, ok := r.err.(*ICMPError)
+				if !ok || icmpErr.Type != step.err.Type || icmpErr.Code != step.err.Code || !icmpErr.Router.Equal(step.err.Router) {
+					t.Errorf("Step %d FAILED: %v (actual) != %v (expected)", i, r.err, step.err)
+				}
+			}
+		default:
+			if step.expected {
+				t.Errorf("Step %d FAILED: reply not delivered", i)
+			}
+		}
+	}
+}
+
+func TestICMPReceiveDuplicates(t *testing.T) {
+	dst := net.IPv4(10, 0, 0, 255)
+	reply42, _ := (&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 0, Seq: 42}}).Marshal(nil)
+
+	steps := []struct {
+		sources []net.IP
+		replies int
+		foreign net.IP
+	}{
+		{
+			sources: []net.IP{dst},
+			replies: 1,
+			foreign: nil,
+		},
+		{
+			sources: []net.IP{dst, dst, dst},
+			replies: 3,
+			foreign: nil,
+		},
+		{
+			sources: []net.IP{net.IPv4(10, 0, 0, 1), dst, net.IPv4(10, 0, 0, 2)},
+			replies: 3,
+			foreign: net.IPv4(10, 0, 0, 1),
+		},
+	}
+
+	for i, step := range steps {
+		p := &ICMP{typ: Echo, requests: make(map[uint32]*request)}
+		req := &request{dst: dst, ch: make(chan reply, 1)}
+		p.requests[42] = req
+
+		for _, src := range step.sources {
+			p.receive(reply42, src, time.Now())
+		}
+
+		if len(req.ch) != 1 {
+			t.Errorf("Step %d FAILED: %d replies passed to channel, expected 1", i, len(req.ch))
+		}
+		if req.replies != step.replies {
+			t.Errorf("Step %d FAILED: %d (actual) != %d (expected) replies", i, req.replies, step.replies)
+		}
+		if !req.foreign.Equal(step.foreign) {
+			t.Errorf("Step %d FAILED: %v (actual) != %v (expected) foreign source", i, req.foreign, step.foreign)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Package probe implements liveness probes: ICMP echo
+// and alternative probes for hosts which drop echo requests.
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Probe types stored with results
+const (
+	Echo      = "echo"
+	Timestamp = "timestamp"
+	TCPAck    = "tcp-ack"
+)
+
+// ErrTimeout is returned when destination did not answer in time
+var ErrTimeout = errors.New("probe timed out")
+
+// Result describes replies to single probe
+type Result struct {
+	RTT     time.Duration // time to first reply
+	Replies int           // amount of replies, more than one for duplicates
+	Foreign net.IP        // first reply source other than destination, nil if none
+}
+
+// Prober sends single probe to destination and waits for response
+type Prober interface {
+	Name() string
+	Probe(*net.IPAddr, time.Duration) (Result, error)
+	Close()
+}
+
+// spec describes single probe from configuration string
+type spec struct {
+	name string
+	port uint16
+}
+
+// parseSpec parses comma-separated list of probes,
+// e.g. "timestamp,tcp-ack:80"
+func parseSpec(str string) (specs []spec, err error) {
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		s := spec{name: parts[0]}
+		switch s.name {
+		case Timestamp:
+			if len(parts) != 1 {
+				return nil, fmt.Errorf("probe %q does not accept port", s.name)
+			}
+		case TCPAck:
+			s.port = 80
+			if len(parts) == 2 {
+				port, err := strconv.ParseUint(parts[1], 10, 16)
+				if err != nil || port == 0 {
+					return nil, fmt.Errorf("wrong port in probe %q", item)
+				}
+				s.port = uint16(port)
+			}
+		default:
+			return nil, fmt.Errorf("unknown probe %q", item)
+		}
+		specs = append(specs, s)
+	}
+	return specs, nil
+}
+
+// Names returns types of probes from comma-separated list (see parseSpec)
+func Names(str string) (names []string, err error) {
+	specs, err := parseSpec(str)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range specs {
+		names = append(names, s.name)
+	}
+	return names, nil
+}
+
+// New creates probers from comma-separated list (see parseSpec).
+// Probers bind raw sockets to given IPv4 address.
+func New(str, bind string) (probers []Prober, err error) {
+	specs, err := parseSpec(str)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range specs {
+		var p Prober
+		switch s.name {
+		case Timestamp:
+			p, err = NewICMP(bind, Timestamp)
+		case TCPAck:
+			p, err = NewTCP(bind, s.port)
+		}
+		if err != nil {
+			for _, p := range probers {
+				p.Close()
+			}
+			return nil, fmt.Errorf("cannot initialize probe %s: %v", s.name, err)
+		}
+		probers = append(probers, p)
+	}
+	return probers, nil
+}
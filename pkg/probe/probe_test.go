@@ -0,0 +1,63 @@
+package probe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		str   string
+		specs []spec
+		err   bool
+	}{
+		{
+			str:   "",
+			specs: nil,
+		},
+		{
+			str:   "timestamp",
+			specs: []spec{{name: Timestamp}},
+		},
+		{
+			str:   "timestamp, tcp-ack",
+			specs: []spec{{name: Timestamp}, {name: TCPAck, port: 80}},
+		},
+		{
+			str:   "tcp-ack:443",
+			specs: []spec{{name: TCPAck, port: 443}},
+		},
+		{
+			str: "tcp-ack:0",
+			err: true,
+		},
+		{
+			str: "tcp-ack:65536",
+			err: true,
+		},
+		{
+			str: "timestamp:80",
+			err: true,
+		},
+		{
+			str: "udp",
+			err: true,
+		},
+	}
+
+	for i, test := range tests {
+		specs, err := parseSpec(test.str)
+		if test.err {
+			if err == nil {
+				t.Errorf("Test %d FAILED: expected error for %q", i, test.str)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d FAILED: unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(specs, test.specs) {
+			t.Errorf("Test %d FAILED: %+v (actual) != %+v (expected)", i, specs, test.specs)
+		}
+	}
+}
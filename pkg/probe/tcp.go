@@ -0,0 +1,196 @@
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	protocolTCP = 6
+
+	flagRST = 0x04
+	flagACK = 0x10
+)
+
+// TCP sends bare TCP ACK segments to the port over raw socket.
+// Live hosts answer with RST even if they filter ICMP.
+// Acknowledgment number is used as key: RST carries it back as sequence number.
+type TCP struct {
+	conn  net.PacketConn
+	src   net.IP
+	port  uint16
+	sport uint16
+	key   uint32
+
+	requests map[uint32]chan time.Time
+	mtx      sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// NewTCP opens raw TCP socket and starts receiver
+func NewTCP(bind string, port uint16) (*TCP, error) {
+	src := net.ParseIP(bind).To4()
+	if src == nil || src.IsUnspecified() {
+		var err error
+		if src, err = defaultSource(); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := net.ListenPacket("ip4:tcp", bind)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &TCP{
+		conn:     conn,
+		src:      src,
+		port:     port,
+		sport:    49152 + uint16(os.Getpid())%16384,
+		requests: make(map[uint32]chan time.Time),
+	}
+	p.wg.Add(1)
+	go p.receiver()
+	return p, nil
+}
+
+// defaultSource returns local address used for outgoing traffic.
+// Connecting UDP socket does not send any packets.
+func defaultSource() (net.IP, error) {
+	conn, err := net.Dial("udp4", "192.0.2.1:9")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	src := conn.LocalAddr().(*net.UDPAddr).IP.To4()
+	if src == nil {
+		return nil, errors.New("no IPv4 source address")
+	}
+	return src, nil
+}
+
+// Name returns probe type
+func (p *TCP) Name() string {
+	return TCPAck
+}
+
+// Probe sends ACK segment and waits for RST
+func (p *TCP) Probe(dst *net.IPAddr, timeout time.Duration) (Result, error) {
+	key := atomic.AddUint32(&p.key, 1)
+	ch := make(chan time.Time, 1)
+
+	p.mtx.Lock()
+	p.requests[key] = ch
+	p.mtx.Unlock()
+
+	defer func() {
+		p.mtx.Lock()
+		delete(p.requests, key)
+		p.mtx.Unlock()
+	}()
+
+	start := time.Now()
+	b := ackSegment(p.src, dst.IP.To4(), p.sport, p.port, key, key)
+	if _, err := p.conn.WriteTo(b, dst); err != nil {
+		return Result{}, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case t := <-ch:
+		return Result{RTT: t.Sub(start), Replies: 1}, nil
+	case <-timer.C:
+		return Result{}, ErrTimeout
+	}
+}
+
+// Close closes socket and waits for receiver
+func (p *TCP) Close() {
+	p.conn.Close()
+	p.wg.Wait()
+}
+
+func (p *TCP) receiver() {
+	defer p.wg.Done()
+
+	b := make([]byte, 1500)
+	for {
+		n, _, err := p.conn.ReadFrom(b)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return // socket closed
+		}
+		p.receive(b[:n], time.Now())
+	}
+}
+
+// receive passes RST segment to the request waiting for it
+func (p *TCP) receive(b []byte, t time.Time) {
+	if len(b) < 20 {
+		return
+	}
+	if binary.BigEndian.Uint16(b[0:]) != p.port || binary.BigEndian.Uint16(b[2:]) != p.sport {
+		return
+	}
+	if b[13]&flagRST == 0 {
+		return
+	}
+	key := binary.BigEndian.Uint32(b[4:])
+
+	p.mtx.Lock()
+	ch := p.requests[key]
+	p.mtx.Unlock()
+
+	if ch != nil {
+		select {
+		case ch <- t:
+		default:
+		}
+	}
+}
+
+// ackSegment builds TCP header with only ACK flag set
+func ackSegment(src, dst net.IP, sport, dport uint16, seq, ack uint32) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint16(b[0:], sport)
+	binary.BigEndian.PutUint16(b[2:], dport)
+	binary.BigEndian.PutUint32(b[4:], seq)
+	binary.BigEndian.PutUint32(b[8:], ack)
+	b[12] = 5 << 4 // data offset in 32-bit words
+	b[13] = flagACK
+	binary.BigEndian.PutUint16(b[14:], 1024) // window
+	binary.BigEndian.PutUint16(b[16:], tcpChecksum(src, dst, b))
+	return b
+}
+
+// tcpChecksum calculates checksum over IPv4 pseudo header and segment
+func tcpChecksum(src, dst net.IP, segment []byte) uint16 {
+	pseudo := make([]byte, 12, 12+len(segment))
+	copy(pseudo[0:], src.To4())
+	copy(pseudo[4:], dst.To4())
+	pseudo[9] = protocolTCP
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(len(segment)))
+	b := append(pseudo, segment...)
+
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,67 @@
+package probe
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAckSegment(t *testing.T) {
+	src := net.IPv4(10, 0, 0, 1)
+	dst := net.IPv4(10, 0, 0, 2)
+
+	b := ackSegment(src, dst, 50000, 80, 7, 42)
+
+	if len(b) != 20 {
+		t.Fatalf("FAILED: wrong length %d", len(b))
+	}
+	if b[13] != flagACK {
+		t.Errorf("FAILED: wrong flags %x", b[13])
+	}
+	if ack := binary.BigEndian.Uint32(b[8:]); ack != 42 {
+		t.Errorf("FAILED: wrong ack %d", ack)
+	}
+	// checksum over segment with valid checksum is zero
+	if sum := tcpChecksum(src, dst, b); sum != 0 {
+		t.Errorf("FAILED: wrong checksum, verification sum %x", sum)
+	}
+}
+
+func TestTCPReceive(t *testing.T) {
+	p := &TCP{port: 80, sport: 50000, requests: make(map[uint32]chan time.Time)}
+	ch := make(chan time.Time, 1)
+	p.requests[42] = ch
+
+	segment := func(sport, dport uint16, seq uint32, flags byte) []byte {
+		b := ackSegment(net.IPv4zero, net.IPv4zero, sport, dport, seq, 0)
+		b[13] = flags
+		return b
+	}
+
+	steps := []struct {
+		packet   []byte
+		expected bool
+	}{
+		{packet: []byte{0, 80}, expected: false},
+		{packet: segment(80, 50000, 42, flagACK), expected: false},
+		{packet: segment(443, 50000, 42, flagRST), expected: false},
+		{packet: segment(80, 50001, 42, flagRST), expected: false},
+		{packet: segment(80, 50000, 43, flagRST), expected: false},
+		{packet: segment(80, 50000, 42, flagRST), expected: true},
+	}
+
+	for i, step := range steps {
+		p.receive(step.packet, time.Now())
+		select {
+		case <-ch:
+			if !step.expected {
+				t.Errorf("Step %d FAILED: unexpected reply delivered", i)
+			}
+		default:
+			if step.expected {
+				t.Errorf("Step %d FAILED: reply not delivered", i)
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package probe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// Traceroute methods
+const (
+	TraceICMP = "icmp"
+	TraceUDP  = "udp"
+)
+
+const (
+	protocolUDP = 17
+
+	traceDstPort = 33434 // traceroute(8) default, constant in Paris traceroute
+	traceGap     = 5     // stop after so many silent hops in a row
+)
+
+// Hop is single traceroute hop, Router is nil if nobody answered
+type Hop struct {
+	TTL    int
+	Router net.IP
+	RTT    time.Duration
+}
+
+// hopReply is passed by receiver to the trace waiting for it
+type hopReply struct {
+	t       time.Time
+	from    net.IP
+	reached bool
+}
+
+// Tracer runs flow-stable (Paris) traceroute.
+// All probes of single trace keep fields used by load balancers for flow hashing
+// constant (ports for UDP, type, code and checksum for ICMP), so they follow the same path.
+// Probes are identified by IP ID which is quoted in ICMP errors.
+type Tracer struct {
+	Method  string
+	MaxTTL  int
+	Timeout time.Duration
+
+	icmp *ipv4.RawConn
+	udp  *ipv4.RawConn
+	key  uint32 // IP ID and echo sequence
+	flow uint32 // echo identifier and UDP source port
+
+	requests map[uint16]chan hopReply
+	mtx      sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// NewTracer opens raw sockets and starts receiver
+func NewTracer(bind, method string, maxTTL int, timeout time.Duration) (*Tracer, error) {
+	if method != TraceICMP && method != TraceUDP {
+		return nil, fmt.Errorf("unknown traceroute method %q", method)
+	}
+
+	c, err := net.ListenPacket("ip4:icmp", bind)
+	if err != nil {
+		return nil, err
+	}
+	icmpConn, err := ipv4.NewRawConn(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	tr := &Tracer{
+		Method:   method,
+		MaxTTL:   maxTTL,
+		Timeout:  timeout,
+		icmp:     icmpConn,
+		requests: make(map[uint16]chan hopReply),
+	}
+
+	if method == TraceUDP {
+		c, err := net.ListenPacket("ip4:udp", bind)
+		if err != nil {
+			icmpConn.Close()
+			return nil, err
+		}
+		if tr.udp, err = ipv4.NewRawConn(c); err != nil {
+			c.Close()
+			icmpConn.Close()
+			return nil, err
+		}
+	}
+
+	tr.wg.Add(1)
+	go tr.receiver()
+	return tr, nil
+}
+
+// Close closes sockets and waits for receiver
+func (tr *Tracer) Close() {
+	if tr.udp != nil {
+		tr.udp.Close()
+	}
+	tr.icmp.Close()
+	tr.wg.Wait()
+}
+
+// Trace sends probes with increasing TTL one by one until destination answers,
+// MaxTTL is reached or too many hops in a row are silent
+func (tr *Tracer) Trace(dst net.IP) (hops []Hop, err error) {
+	flow := uint16(atomic.AddUint32(&tr.flow, 1))
+	if tr.Method == TraceUDP {
+		flow = 49152 + flow%16384 // source port from ephemeral range
+	}
+
+	silent := 0
+	for ttl := 1; ttl <= tr.MaxTTL && silent < traceGap; ttl++ {
+		hop, reached, err := tr.probe(dst, ttl, flow)
+		if err != nil {
+			return hops, err
+		}
+		hops = append(hops, hop)
+		if reached {
+			break
+		}
+		if hop.Router == nil {
+			silent++
+		} else {
+			silent = 0
+		}
+	}
+	return hops, nil
+}
+
+// probe sends single probe with given TTL and waits for answer
+func (tr *Tracer) probe(dst net.IP, ttl int, flow uint16) (hop Hop, reached bool, err error) {
+	hop.TTL = ttl
+	key := uint16(atomic.AddUint32(&tr.key, 1))
+	ch := make(chan hopReply, 1)
+
+	tr.mtx.Lock()
+	tr.requests[key] = ch
+	tr.mtx.Unlock()
+
+	defer func() {
+		tr.mtx.Lock()
+		delete(tr.requests, key)
+		tr.mtx.Unlock()
+	}()
+
+	hdr := &ipv4.Header{
+		Version: ipv4.Version,
+		Len:     ipv4.HeaderLen,
+		ID:      int(key),
+		TTL:     ttl,
+		Dst:     dst.To4(),
+	}
+	var payload []byte
+	conn := tr.icmp
+	if tr.Method == TraceUDP {
+		hdr.Protocol = protocolUDP
+		payload = udpProbe(flow)
+		conn = tr.udp
+	} else {
+		hdr.Protocol = protocolICMP
+		if payload, err = parisEcho(flow, key); err != nil {
+			return hop, false, err
+		}
+	}
+	hdr.TotalLen = ipv4.HeaderLen + len(payload)
+
+	start := time.Now()
+	if err := conn.WriteTo(hdr, payload, nil); err != nil {
+		return hop, false, err
+	}
+
+	timer := time.NewTimer(tr.Timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-ch:
+		hop.Router = r.from
+		hop.RTT = r.t.Sub(start)
+		return hop, r.reached, nil
+	case <-timer.C:
+		return hop, false, nil
+	}
+}
+
+func (tr *Tracer) receiver() {
+	defer tr.wg.Done()
+
+	b := make([]byte, 1500)
+	for {
+		hdr, payload, _, err := tr.icmp.ReadFrom(b)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return // socket closed
+		}
+		tr.receive(payload, hdr.Src, time.Now())
+	}
+}
+
+// receive passes answer to probe waiting for it.
+// Routers answer with time exceeded, destination - with echo reply
+// (ICMP method) or port unreachable (UDP method).
+func (tr *Tracer) receive(b []byte, from net.IP, t time.Time) {
+	m, err := icmp.ParseMessage(protocolICMP, b)
+	if err != nil {
+		return
+	}
+
+	var key uint16
+	r := hopReply{t: t, from: from}
+
+	switch m.Type {
+	case ipv4.ICMPTypeEchoReply:
+		echo, ok := m.Body.(*icmp.Echo)
+		if !ok || tr.Method != TraceICMP {
+			return
+		}
+		key = uint16(echo.Seq)
+		r.reached = true
+	case ipv4.ICMPTypeTimeExceeded, ipv4.ICMPTypeDestinationUnreachable:
+		var data []byte
+		if body, ok := m.Body.(*icmp.TimeExceeded); ok {
+			data = body.Data
+		} else if body, ok := m.Body.(*icmp.DstUnreach); ok {
+			data = body.Data
+			r.reached = true
+		}
+		hdr, err := ipv4.ParseHeader(data)
+		if err != nil {
+			return
+		}
+		if (tr.Method == TraceICMP) != (hdr.Protocol == protocolICMP) {
+			return
+		}
+		key = uint16(hdr.ID)
+	default:
+		return
+	}
+
+	tr.mtx.Lock()
+	ch := tr.requests[key]
+	tr.mtx.Unlock()
+
+	if ch != nil {
+		select {
+		case ch <- r:
+		default:
+		}
+	}
+}
+
+// parisEcho builds echo request with constant checksum for given identifier:
+// first payload word complements sequence number, so their sum does not change.
+func parisEcho(id, seq uint16) ([]byte, error) {
+	data := make([]byte, payloadSize)
+	binary.BigEndian.PutUint16(data, ^seq)
+
+	m := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: int(id), Seq: int(seq), Data: data},
+	}
+	return m.Marshal(nil)
+}
+
+// udpProbe builds UDP datagram with constant ports.
+// Zero checksum means "no checksum" in IPv4.
+func udpProbe(sport uint16) []byte {
+	b := make([]byte, 8+payloadSize)
+	binary.BigEndian.PutUint16(b[0:], sport)
+	binary.BigEndian.PutUint16(b[2:], traceDstPort)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(b)))
+	return b
+}
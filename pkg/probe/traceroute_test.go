@@ -0,0 +1,112 @@
+package probe
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func TestParisEcho(t *testing.T) {
+	var checksum uint16
+	for i, seq := range []uint16{0, 1, 2, 255, 0x8000, 0xffff} {
+		b, err := parisEcho(1234, seq)
+		if err != nil {
+			t.Fatalf("Cannot build request: %v", err)
+		}
+		m, err := icmp.ParseMessage(protocolICMP, b)
+		if err != nil {
+			t.Fatalf("Cannot parse request: %v", err)
+		}
+		if echo := m.Body.(*icmp.Echo); echo.ID != 1234 || echo.Seq != int(seq) {
+			t.Errorf("Test %d FAILED: wrong echo %+v", i, echo)
+		}
+
+		sum := binary.BigEndian.Uint16(b[2:4])
+		if i == 0 {
+			checksum = sum
+		} else if sum != checksum {
+			t.Errorf("Test %d FAILED: checksum %x changed (expected %x)", i, sum, checksum)
+		}
+	}
+}
+
+func TestUDPProbe(t *testing.T) {
+	b := udpProbe(50000)
+	if sport := binary.BigEndian.Uint16(b[0:]); sport != 50000 {
+		t.Errorf("FAILED: wrong source port %d", sport)
+	}
+	if dport := binary.BigEndian.Uint16(b[2:]); dport != traceDstPort {
+		t.Errorf("FAILED: wrong destination port %d", dport)
+	}
+	if length := binary.BigEndian.Uint16(b[4:]); int(length) != len(b) {
+		t.Errorf("FAILED: wrong length %d", length)
+	}
+}
+
+// quotedError builds ICMP error quoting original packet with given IP ID and protocol
+func quotedError(typ ipv4.ICMPType, id, protocol int) []byte {
+	hdr := ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen,
+		TotalLen: ipv4.HeaderLen + 8,
+		ID:       id,
+		TTL:      1,
+		Protocol: protocol,
+		Dst:      net.IPv4(10, 0, 0, 2),
+	}
+	data, _ := hdr.Marshal()
+	data = append(data, make([]byte, 8)...)
+
+	var body icmp.MessageBody = &icmp.TimeExceeded{Data: data}
+	if typ == ipv4.ICMPTypeDestinationUnreachable {
+		body = &icmp.DstUnreach{Data: data}
+	}
+	b, _ := (&icmp.Message{Type: typ, Body: body}).Marshal(nil)
+	return b
+}
+
+func TestTracerReceive(t *testing.T) {
+	echoReply, _ := (&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 1, Seq: 42}}).Marshal(nil)
+
+	steps := []struct {
+		method   string
+		packet   []byte
+		expected bool
+		reached  bool
+	}{
+		{method: TraceICMP, packet: []byte{1, 2, 3}, expected: false},
+		{method: TraceICMP, packet: quotedError(ipv4.ICMPTypeTimeExceeded, 43, protocolICMP), expected: false},
+		{method: TraceICMP, packet: quotedError(ipv4.ICMPTypeTimeExceeded, 42, protocolUDP), expected: false},
+		{method: TraceICMP, packet: quotedError(ipv4.ICMPTypeTimeExceeded, 42, protocolICMP), expected: true, reached: false},
+		{method: TraceICMP, packet: echoReply, expected: true, reached: true},
+		{method: TraceUDP, packet: echoReply, expected: false},
+		{method: TraceUDP, packet: quotedError(ipv4.ICMPTypeTimeExceeded, 42, protocolUDP), expected: true, reached: false},
+		{method: TraceUDP, packet: quotedError(ipv4.ICMPTypeDestinationUnreachable, 42, protocolUDP), expected: true, reached: true},
+	}
+
+	from := net.IPv4(192, 0, 2, 1)
+	for i, step := range steps {
+		tr := &Tracer{Method: step.method, requests: make(map[uint16]chan hopReply)}
+		ch := make(chan hopReply, 1)
+		tr.requests[42] = ch
+
+		tr.receive(step.packet, from, time.Now())
+		select {
+		case r := <-ch:
+			if !step.expected {
+				t.Errorf("Step %d FAILED: unexpected reply delivered", i)
+			}
+			if r.reached != step.reached || !r.from.Equal(from) {
+				t.Errorf("Step %d FAILED: wrong reply %+v", i, r)
+			}
+		default:
+			if step.expected {
+				t.Errorf("Step %d FAILED: reply not delivered", i)
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Package rdns performs reverse DNS (PTR) lookups against configurable resolver.
+package rdns
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"time"
+)
+
+// Resolver performs PTR lookups
+type Resolver struct {
+	r       *net.Resolver
+	timeout time.Duration
+}
+
+// New creates resolver sending queries to server (host:port).
+// System resolver is used if server is empty.
+func New(server string, timeout time.Duration) *Resolver {
+	r := &net.Resolver{}
+	if server != "" {
+		r.PreferGo = true
+		r.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		}
+	}
+	return &Resolver{r: r, timeout: timeout}
+}
+
+// Lookup returns first PTR record of IP without trailing dot.
+// Empty hostname and nil error are returned if there is no PTR record,
+// so such IPs are not looked up again too often.
+func (r *Resolver) Lookup(ctx context.Context, ip uint32) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, ip)
+
+	names, err := r.r.LookupAddr(ctx, net.IP(buf).String())
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	return strings.TrimSuffix(names[0], "."), nil
+}
@@ -0,0 +1,97 @@
+package rdns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveDNS runs stand-in DNS server answering PTR queries from records,
+// other queries are answered with NXDOMAIN (or SERVFAIL for names in failing)
+func serveDNS(t *testing.T, records map[string]string, failing map[string]bool) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot start DNS server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(b)
+			if err != nil {
+				return
+			}
+
+			var query dnsmessage.Message
+			if err := query.Unpack(b[:n]); err != nil || len(query.Questions) != 1 {
+				continue
+			}
+			q := query.Questions[0]
+
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, RecursionAvailable: true},
+				Questions: query.Questions,
+			}
+			name := q.Name.String()
+			if ptr, ok := records[name]; ok && q.Type == dnsmessage.TypePTR {
+				resp.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(ptr)},
+				}}
+			} else if failing[name] {
+				resp.RCode = dnsmessage.RCodeServerFailure
+			} else {
+				resp.RCode = dnsmessage.RCodeNameError
+			}
+
+			packed, err := resp.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(packed, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestLookup(t *testing.T) {
+	server := serveDNS(t,
+		map[string]string{"8.8.8.8.in-addr.arpa.": "dns.google."},
+		map[string]bool{"1.1.1.1.in-addr.arpa.": true},
+	)
+	r := New(server, time.Second)
+
+	tests := []struct {
+		ip       uint32
+		hostname string
+		err      bool
+	}{
+		{
+			ip:       134744072, // 8.8.8.8
+			hostname: "dns.google",
+		},
+		{
+			ip:       134743044, // 8.8.4.4
+			hostname: "",
+		},
+		{
+			ip:  16843009, // 1.1.1.1
+			err: true,
+		},
+	}
+
+	for i, test := range tests {
+		hostname, err := r.Lookup(context.Background(), test.ip)
+		if (err != nil) != test.err {
+			t.Errorf("Test %d FAILED: unexpected error %v", i, err)
+		}
+		if hostname != test.hostname {
+			t.Errorf("Test %d FAILED: %q (actual) != %q (expected)", i, hostname, test.hostname)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package sink
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+// Sink is destination of result batches
+type Sink interface {
+	Write(types.Batch) error
+}
+
+// Func adapts function to Sink
+type Func func(types.Batch) error
+
+// Write calls f(batch)
+func (f Func) Write(batch types.Batch) error {
+	return f(batch)
+}
+
+// Options of delivery to sink
+type Options struct {
+	Name    string
+	Workers int           // batches written at once (1 if not set)
+	Queue   int           // batches waiting for workers, batches are not queued while all workers are busy if 0
+	Retries int           // attempts after failed write
+	Backoff time.Duration // delay before first retry (1s if not set), doubled for every next one
+	Block   bool          // wait when queue is full, otherwise batch is dropped
+}
+
+// maxBackoff limits delay between retries
+const maxBackoff = time.Minute
+
+// Metrics are published with expvar as "sinks" map of per sink maps
+var metrics = expvar.NewMap("sinks")
+
+// Fanout delivers batches to several sinks. Every sink has its own queue, workers and retries,
+// so slow or failing sink does not stall others (unless it blocks when its queue is full).
+type Fanout struct {
+	Errorf   func(format string, args ...interface{}) // reports failed and dropped batches (if set)
+	Warningf func(format string, args ...interface{}) // reports waiting for blocking sink (if set)
+
+	outs []*out
+}
+
+type out struct {
+	Options
+	sink Sink
+	ch   chan types.Batch
+	wg   sync.WaitGroup
+
+	queued, saving, saved, retried, failed, dropped expvar.Int
+}
+
+// Add starts delivery to sink, should not be called after Put
+func (f *Fanout) Add(sink Sink, opts Options) {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	o := &out{Options: opts, sink: sink, ch: make(chan types.Batch, opts.Queue)}
+
+	m := new(expvar.Map).Init()
+	m.Set("queued", &o.queued)   // batches waiting for worker
+	m.Set("saving", &o.saving)   // batches being written
+	m.Set("saved", &o.saved)     // batches written
+	m.Set("retried", &o.retried) // failed writes retried
+	m.Set("failed", &o.failed)   // batches failed after all retries
+	m.Set("dropped", &o.dropped) // batches dropped due to full queue
+	metrics.Set(opts.Name, m)
+
+	for i := 0; i < opts.Workers; i++ {
+		o.wg.Add(1)
+		go f.work(o)
+	}
+	f.outs = append(f.outs, o)
+}
+
+// Put queues batch to every sink
+func (f *Fanout) Put(batch types.Batch) {
+	for _, o := range f.outs {
+		o.queued.Add(1)
+		select {
+		case o.ch <- batch:
+			continue
+		default:
+		}
+		if !o.Block {
+			o.queued.Add(-1)
+			o.dropped.Add(1)
+			f.errorf("Sink %s falls behind (%d batches queued), dropping batch %s of %d results", o.Name, len(o.ch), batch.ID, len(batch.Results))
+			continue
+		}
+		f.warningf("Sink %s falls behind (%d batches queued), waiting", o.Name, len(o.ch))
+		o.ch <- batch
+	}
+}
+
+// Queued returns amount of batches waiting for sink
+func (f *Fanout) Queued(name string) int64 {
+	for _, o := range f.outs {
+		if o.Name == name {
+			return o.queued.Value()
+		}
+	}
+	return 0
+}
+
+// Close waits until queued batches are delivered
+func (f *Fanout) Close() {
+	for _, o := range f.outs {
+		close(o.ch)
+	}
+	for _, o := range f.outs {
+		o.wg.Wait()
+	}
+}
+
+// work writes batches until queue is closed
+func (f *Fanout) work(o *out) {
+	defer o.wg.Done()
+
+	for batch := range o.ch {
+		o.queued.Add(-1)
+		o.saving.Add(1)
+		backoff := o.Backoff
+		for attempt := 0; ; attempt++ {
+			err := o.sink.Write(batch)
+			if err == nil {
+				o.saved.Add(1)
+				break
+			}
+			if attempt >= o.Retries {
+				o.failed.Add(1)
+				f.errorf("Cannot write batch %s to sink %s, dropping it: %v", batch.ID, o.Name, err)
+				break
+			}
+			o.retried.Add(1)
+			f.errorf("Cannot write batch %s to sink %s (retry in %v): %v", batch.ID, o.Name, backoff, err)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		o.saving.Add(-1)
+	}
+}
+
+func (f *Fanout) errorf(format string, args ...interface{}) {
+	if f.Errorf != nil {
+		f.Errorf(format, args...)
+	}
+}
+
+func (f *Fanout) warningf(format string, args ...interface{}) {
+	if f.Warningf != nil {
+		f.Warningf(format, args...)
+	}
+}
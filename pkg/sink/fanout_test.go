@@ -0,0 +1,88 @@
+package sink
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func TestFanoutRetry(t *testing.T) {
+	var mtx sync.Mutex
+	attempts := map[string]int{}
+	failing := func(name string, failures int) Sink {
+		return Func(func(types.Batch) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			if attempts[name]++; attempts[name] <= failures {
+				return errors.New("some error")
+			}
+			return nil
+		})
+	}
+
+	f := &Fanout{}
+	f.Add(failing("flaky", 2), Options{Name: "flaky", Queue: 1, Retries: 2, Backoff: time.Millisecond})
+	f.Add(failing("broken", 10), Options{Name: "broken", Queue: 1, Retries: 1, Backoff: time.Millisecond})
+	f.Put(types.Batch{Results: types.Tasks{{IP: 1}}})
+	f.Close()
+
+	// flaky sink succeeds on the last retry, broken one gives up after first retry
+	if attempts["flaky"] != 3 || attempts["broken"] != 2 {
+		t.Errorf("FAILED: wrong write attempts %v", attempts)
+	}
+	if m := f.outs[0]; m.saved.Value() != 1 || m.retried.Value() != 2 {
+		t.Errorf("FAILED: flaky sink saved %d, retried %d", m.saved.Value(), m.retried.Value())
+	}
+	if m := f.outs[1]; m.failed.Value() != 1 {
+		t.Errorf("FAILED: broken sink failed %d batches instead of 1", m.failed.Value())
+	}
+}
+
+func TestFanoutIsolation(t *testing.T) {
+	unblock := make(chan struct{})
+	slow := Func(func(types.Batch) error {
+		<-unblock
+		return nil
+	})
+	saved := make(chan types.Batch, 3)
+	fast := Func(func(b types.Batch) error {
+		saved <- b
+		return nil
+	})
+
+	f := &Fanout{}
+	f.Add(slow, Options{Name: "slow", Queue: 1})
+	f.Add(fast, Options{Name: "fast", Queue: 3})
+
+	// slow sink is busy with the first batch and has the second one queued, the third one is dropped
+	for seq := uint64(1); seq <= 3; seq++ {
+		done := make(chan struct{})
+		go func() {
+			f.Put(types.Batch{ID: types.BatchID{Seq: seq}})
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("FAILED: slow sink blocked batch %d", seq)
+		}
+		if b := <-saved; b.ID.Seq != seq {
+			t.Errorf("FAILED: fast sink saved batch %d instead of %d", b.ID.Seq, seq)
+		}
+		if seq == 1 {
+			// wait for worker to take the first batch
+			for f.outs[0].saving.Value() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	close(unblock)
+	f.Close()
+
+	if m := f.outs[0]; m.saved.Value() != 2 || m.dropped.Value() != 1 {
+		t.Errorf("FAILED: slow sink saved %d, dropped %d", m.saved.Value(), m.dropped.Value())
+	}
+}
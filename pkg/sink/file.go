@@ -0,0 +1,268 @@
+// Package sink implements destinations of scan results and delivery of results to several of them.
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+	"github.com/nanorobocop/worldping/pkg/utils"
+)
+
+// Formats of file sink
+const (
+	JSONL = "jsonl"
+	CSV   = "csv"
+)
+
+// Stdout is Path of file sink writing to standard output
+const Stdout = "-"
+
+// rotatedFormat is timestamp inserted into name of rotated file
+const rotatedFormat = "20060102T150405.000"
+
+var csvHeader = []string{"ip", "vantage", "ping", "probe", "icmp_type", "icmp_code", "router", "replies", "anomalies", "reply_from", "time", "rtt", "source"}
+
+// record is result as written to file, addresses are dotted, RTT is in milliseconds, zero values are omitted from JSON
+type record struct {
+	IP        string    `json:"ip"`
+	Vantage   string    `json:"vantage,omitempty"`
+	Ping      bool      `json:"ping"`
+	Probe     string    `json:"probe,omitempty"`
+	ICMPType  uint8     `json:"icmp_type,omitempty"`
+	ICMPCode  uint8     `json:"icmp_code,omitempty"`
+	Router    string    `json:"router,omitempty"`
+	Replies   uint16    `json:"replies,omitempty"`
+	Anomalies uint8     `json:"anomalies,omitempty"`
+	ReplyFrom string    `json:"reply_from,omitempty"`
+	Time      time.Time `json:"time"`
+	RTT       float64   `json:"rtt,omitempty"`
+	Source    string    `json:"source,omitempty"`
+}
+
+func newRecord(t types.Task, now time.Time) record {
+	r := record{IP: utils.IPToStr(t.IP), Vantage: t.Vantage, Ping: t.Ping, Probe: t.Probe, Replies: t.Replies, Anomalies: t.Anomalies, Time: now,
+		RTT: float64(t.RTT) / float64(time.Millisecond), Source: t.Source}
+	if !t.Time.IsZero() {
+		r.Time = t.Time
+	}
+	if t.ICMPType != 0 {
+		r.ICMPType, r.ICMPCode = t.ICMPType, t.ICMPCode
+		if t.Router != 0 {
+			r.Router = utils.IPToStr(t.Router)
+		}
+	}
+	if t.ReplyFrom != 0 {
+		r.ReplyFrom = utils.IPToStr(t.ReplyFrom)
+	}
+	return r
+}
+
+func (r record) csv() []string {
+	return []string{r.IP, r.Vantage, strconv.FormatBool(r.Ping), r.Probe, strconv.Itoa(int(r.ICMPType)), strconv.Itoa(int(r.ICMPCode)),
+		r.Router, strconv.Itoa(int(r.Replies)), strconv.Itoa(int(r.Anomalies)), r.ReplyFrom, r.Time.Format(time.RFC3339),
+		strconv.FormatFloat(r.RTT, 'f', -1, 64), r.Source}
+}
+
+// File writes results to file (or stdout) as JSON lines or CSV with header.
+// File at Path is appended to, it is rotated when it grows over MaxSize bytes or
+// gets older than MaxAge (checked before every write): renamed with timestamp before extension,
+// e.g. results.jsonl.gz -> results-20210102T150405.000.jsonl.gz, and new file is started.
+// Every batch is flushed, so gzipped file is readable up to the last batch while being written.
+type File struct {
+	Path    string        // Stdout to write to standard output (never rotated)
+	Format  string        // JSONL (default) or CSV
+	Gzip    bool          // compress output
+	MaxSize int64         // 0 - no rotation by size
+	MaxAge  time.Duration // 0 - no rotation by time
+
+	mtx    sync.Mutex
+	f      *os.File
+	gz     *gzip.Writer
+	buf    *bufio.Writer
+	size   int64 // bytes written to file, compressed
+	opened time.Time
+	dirty  bool // file contains results, not only header
+}
+
+// Open checks configuration and opens file
+func (s *File) Open() error {
+	switch s.Format {
+	case "":
+		s.Format = JSONL
+	case JSONL, CSV:
+	default:
+		return fmt.Errorf("unknown format %q (should be %s or %s)", s.Format, JSONL, CSV)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.open(time.Now())
+}
+
+// open should be called with mutex held
+func (s *File) open(now time.Time) (err error) {
+	s.size = 0
+	s.opened = now
+	s.dirty = false
+	if s.Path == Stdout {
+		s.f = os.Stdout
+	} else {
+		if s.f, err = os.OpenFile(s.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			return err
+		}
+		info, err := s.f.Stat()
+		if err != nil {
+			s.f.Close()
+			return err
+		}
+		s.size = info.Size()
+		s.dirty = s.size > 0
+	}
+
+	var w io.Writer = countingWriter{s.f, &s.size}
+	if s.Gzip {
+		// appending to gzipped file adds gzip member, readers decompress members one after another
+		s.gz = gzip.NewWriter(w)
+		w = s.gz
+	}
+	s.buf = bufio.NewWriter(w)
+	if s.Format == CSV && s.size == 0 {
+		cw := csv.NewWriter(s.buf)
+		cw.Write(csvHeader)
+		cw.Flush()
+		return s.flush()
+	}
+	return nil
+}
+
+// Save writes batch of results, file is rotated before if needed
+func (s *File) Save(results types.Tasks) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.buf == nil {
+		return fmt.Errorf("file sink %s is closed", s.Path)
+	}
+	now := time.Now()
+	if s.Path != Stdout && s.dirty && (s.MaxSize > 0 && s.size >= s.MaxSize || s.MaxAge > 0 && now.Sub(s.opened) >= s.MaxAge) {
+		if err := s.rotate(now); err != nil {
+			return err
+		}
+	}
+	var cw *csv.Writer
+	var enc *json.Encoder
+	if s.Format == CSV {
+		cw = csv.NewWriter(s.buf)
+	} else {
+		enc = json.NewEncoder(s.buf)
+	}
+	for _, t := range results {
+		r := newRecord(t, now)
+		var err error
+		if cw != nil {
+			err = cw.Write(r.csv())
+		} else {
+			err = enc.Encode(r)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if cw != nil {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+	s.dirty = true
+	return s.flush()
+}
+
+// Write saves results of batch, so File is Sink
+func (s *File) Write(batch types.Batch) error {
+	return s.Save(batch.Results)
+}
+
+// flush writes buffered data to file, gzip stream is flushed to byte boundary
+func (s *File) flush() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if s.gz != nil {
+		return s.gz.Flush()
+	}
+	return nil
+}
+
+// rotate closes current file, renames it and opens new one.
+// If renaming fails, current file is opened again.
+func (s *File) rotate(now time.Time) error {
+	err := s.close()
+	if err == nil {
+		err = os.Rename(s.Path, rotatedName(s.Path, now))
+	}
+	if openErr := s.open(now); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// rotatedName inserts timestamp before extensions of file name
+func rotatedName(path string, now time.Time) string {
+	dir, name := filepath.Split(path)
+	ext := ""
+	if i := strings.Index(name, "."); i > 0 {
+		name, ext = name[:i], name[i:]
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s-%s%s", name, now.Format(rotatedFormat), ext))
+}
+
+// close should be called with mutex held
+func (s *File) close() (err error) {
+	if s.buf == nil {
+		return nil
+	}
+	err = s.buf.Flush()
+	if s.gz != nil {
+		if gzErr := s.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if s.f != os.Stdout {
+		if closeErr := s.f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	s.f, s.gz, s.buf = nil, nil, nil
+	return err
+}
+
+// Close flushes and closes file
+func (s *File) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.close()
+}
+
+// countingWriter counts bytes written
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,118 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+var results = types.Tasks{
+	{IP: 1<<24 + 1, Ping: true, Probe: "echo", Replies: 2, Anomalies: types.AnomalyDuplicate},
+	{IP: 1<<24 + 2, Vantage: "eu", ICMPType: 3, ICMPCode: 13, Router: 1<<24 + 254, Source: "zmap"},
+}
+
+func TestFileJSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	s := &File{Path: path}
+	if err := s.Open(); err != nil {
+		t.Fatalf("Cannot open: %v", err)
+	}
+	if err := s.Save(results); err != nil {
+		t.Fatalf("FAILED: save: %v", err)
+	}
+	s.Close()
+
+	f, _ := os.Open(path)
+	defer f.Close()
+	var records []record
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var r record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatalf("FAILED: wrong line %q: %v", sc.Text(), err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 2 || records[0].IP != "1.0.0.1" || !records[0].Ping || records[0].Replies != 2 ||
+		records[1].Router != "1.0.0.254" || records[1].ICMPCode != 13 || records[1].Vantage != "eu" || records[1].Source != "zmap" || records[1].Time.IsZero() {
+		t.Errorf("FAILED: wrong records %+v", records)
+	}
+}
+
+func TestFileCSVGzipRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "results.csv.gz")
+	s := &File{Path: path, Format: CSV, Gzip: true, MaxSize: 1}
+	if err := s.Open(); err != nil {
+		t.Fatalf("Cannot open: %v", err)
+	}
+	// every batch goes to its own file
+	for i := 0; i < 3; i++ {
+		if err := s.Save(results); err != nil {
+			t.Fatalf("FAILED: save: %v", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	s.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "results-*.csv.gz"))
+	if len(files) != 2 {
+		t.Fatalf("FAILED: %d rotated files instead of 2", len(files))
+	}
+	for _, name := range files {
+		f, _ := os.Open(name)
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("FAILED: %s is not gzipped: %v", name, err)
+		}
+		rows, err := csv.NewReader(gz).ReadAll()
+		f.Close()
+		if err != nil || len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(csvHeader, ",") || rows[2][6] != "1.0.0.254" || rows[2][12] != "zmap" {
+			t.Errorf("FAILED: wrong rows in %s: %v, %v", name, rows, err)
+		}
+	}
+	// the last batch is in current file
+	if b, err := ioutil.ReadFile(path); err != nil || len(b) == 0 {
+		t.Errorf("FAILED: current file should have results: %v", err)
+	}
+}
+
+func TestFileAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.csv")
+	for i := 0; i < 2; i++ {
+		s := &File{Path: path, Format: CSV}
+		if err := s.Open(); err != nil {
+			t.Fatalf("Cannot open: %v", err)
+		}
+		s.Save(results[:1])
+		s.Close()
+	}
+	f, _ := os.Open(path)
+	defer f.Close()
+	// header is written once
+	if rows, err := csv.NewReader(f).ReadAll(); err != nil || len(rows) != 3 {
+		t.Errorf("FAILED: wrong rows %v, %v", rows, err)
+	}
+}
+
+func TestRotatedName(t *testing.T) {
+	now := time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := map[string]string{
+		"results.jsonl.gz":    "results-20210102T150405.000.jsonl.gz",
+		"/var/out/results":    "/var/out/results-20210102T150405.000",
+		"/var/out.d/data.csv": "/var/out.d/data-20210102T150405.000.csv",
+	}
+	for path, exp := range tests {
+		if actual := rotatedName(path, now); actual != exp {
+			t.Errorf("FAILED: %s (actual) != %s (expected)", actual, exp)
+		}
+	}
+}
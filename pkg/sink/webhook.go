@@ -0,0 +1,72 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+// webhookRequest is body of request, results are in the same format as JSONL file ones.
+// Offset is index of the first result in batch, so retried parts could be deduplicated by batch and offset.
+type webhookRequest struct {
+	Batch   string   `json:"batch,omitempty"`
+	Offset  int      `json:"offset"`
+	Results []record `json:"results"`
+}
+
+// Webhook posts results as JSON to URL, batch is split into requests of BatchSize results at most.
+// Any response other than 2xx fails the write.
+type Webhook struct {
+	URL       string
+	BatchSize int          // 0 - whole batch in single request
+	Client    *http.Client // http.DefaultClient if nil
+}
+
+// Write posts batch
+func (w *Webhook) Write(batch types.Batch) error {
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	size := w.BatchSize
+	if size <= 0 {
+		size = len(batch.Results)
+	}
+
+	var id string
+	if !batch.ID.IsZero() {
+		id = batch.ID.String()
+	}
+	now := time.Now()
+	for offset := 0; offset < len(batch.Results); offset += size {
+		end := offset + size
+		if end > len(batch.Results) {
+			end = len(batch.Results)
+		}
+		req := webhookRequest{Batch: id, Offset: offset, Results: make([]record, 0, end-offset)}
+		for _, t := range batch.Results[offset:end] {
+			req.Results = append(req.Results, newRecord(t, now))
+		}
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("webhook replied %s", resp.Status)
+		}
+	}
+	return nil
+}
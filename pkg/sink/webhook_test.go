@@ -0,0 +1,44 @@
+package sink
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func TestWebhook(t *testing.T) {
+	var reqs []webhookRequest
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("FAILED: cannot decode request: %v", err)
+		}
+		reqs = append(reqs, req)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	// batch is split into requests of BatchSize results
+	w := &Webhook{URL: srv.URL, BatchSize: 2}
+	id := types.BatchID{Worker: "w1", Started: time.Unix(0, 1), Seq: 3}
+	batch := types.Batch{ID: id, Results: types.Tasks{{IP: 1, Ping: true}, {IP: 2}, {IP: 3}}}
+	if err := w.Write(batch); err != nil {
+		t.Fatalf("FAILED: %v", err)
+	}
+	if len(reqs) != 2 {
+		t.Fatalf("FAILED: %d requests instead of 2", len(reqs))
+	}
+	if r := reqs[1]; r.Batch != "w1/1/3" || r.Offset != 2 || len(r.Results) != 1 || r.Results[0].IP != "0.0.0.3" {
+		t.Errorf("FAILED: wrong second request %+v", r)
+	}
+
+	status = http.StatusInternalServerError
+	if err := w.Write(batch); err == nil {
+		t.Errorf("FAILED: no error on %d reply", status)
+	}
+}
@@ -0,0 +1,108 @@
+package snapshot
+
+import "fmt"
+
+// Delta is change of responsive addresses of prefix between rounds
+type Delta struct {
+	Start         uint32
+	Prefix        int
+	Before, After int // responsive addresses
+	Added         int // responsive in new round only
+	Removed       int // responsive in old round only
+}
+
+// Diff compares responsive addresses in range [start, end] probed in both rounds, addresses probed
+// in one of them only are skipped. Addresses responsive after only (added) or before only
+// (removed) are passed to f in ascending order. Deltas of prefixes of length prefix with changes are returned.
+func Diff(before, after *Snapshot, start, end uint32, prefix int, f func(ip uint32, added bool) error) (deltas []Delta, err error) {
+	if prefix < 0 || prefix > 32 {
+		return nil, fmt.Errorf("wrong prefix length %d", prefix)
+	}
+	mask := uint32(0xffffffff) << uint(32-prefix) // 0 for /0
+	var delta Delta
+	started := false
+	flush := func() {
+		if delta.Added != 0 || delta.Removed != 0 {
+			deltas = append(deltas, delta)
+		}
+	}
+
+	for i, j := 0, 0; i < len(before.Ranges) && j < len(after.Ranges); {
+		o, n := before.Ranges[i], after.Ranges[j]
+		if o.Start < n.Start {
+			i++
+			continue
+		}
+		if n.Start < o.Start {
+			j++
+			continue
+		}
+		i, j = i+1, j+1
+		if o.Start > end || o.Start|0xffff < start {
+			continue
+		}
+
+		err = union(&o.Responsive, &n.Responsive, func(v uint16, inOld, inNew bool) error {
+			ip := o.Start | uint32(v)
+			if ip < start || ip > end || !o.Probed.Contains(v) || !n.Probed.Contains(v) {
+				return nil
+			}
+			if key := ip & mask; !started || key != delta.Start {
+				flush()
+				delta = Delta{Start: key, Prefix: prefix}
+				started = true
+			}
+			if inOld {
+				delta.Before++
+			}
+			if inNew {
+				delta.After++
+			}
+			if inOld == inNew {
+				return nil
+			}
+			if inNew {
+				delta.Added++
+			} else {
+				delta.Removed++
+			}
+			return f(ip, inNew)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	flush()
+	return deltas, nil
+}
+
+// union passes values of both sets to f in ascending order with sets containing them
+func union(a, b *Set, f func(v uint16, inA, inB bool) error) error {
+	values := func(s *Set) []uint16 {
+		vs := make([]uint16, 0, s.Len())
+		s.Each(func(v uint16) error {
+			vs = append(vs, v)
+			return nil
+		})
+		return vs
+	}
+	va, vb := values(a), values(b)
+	for i, j := 0, 0; i < len(va) || j < len(vb); {
+		var err error
+		switch {
+		case j == len(vb) || i < len(va) && va[i] < vb[j]:
+			err = f(va[i], true, false)
+			i++
+		case i == len(va) || vb[j] < va[i]:
+			err = f(vb[j], false, true)
+			j++
+		default:
+			err = f(va[i], true, true)
+			i, j = i+1, j+1
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package snapshot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func TestDiff(t *testing.T) {
+	build := func(results []types.Task) *Snapshot {
+		s := New("", false)
+		for _, r := range results {
+			if err := s.Add(r); err != nil {
+				t.Fatalf("TestDiff FAILED: add: %v", err)
+			}
+		}
+		return s
+	}
+	before := build([]types.Task{
+		{IP: 1 << 24, Ping: true},
+		{IP: 1<<24 + 1, Ping: true},
+		{IP: 1<<24 + 2},
+		{IP: 1<<24 + 256, Ping: true},
+		{IP: 2 << 24, Ping: true}, // not probed after
+		{IP: 1<<32 - 1},
+	})
+	after := build([]types.Task{
+		{IP: 1 << 24, Ping: true},
+		{IP: 1<<24 + 1},
+		{IP: 1<<24 + 2, Ping: true},
+		{IP: 1<<24 + 256},
+		{IP: 3 << 24, Ping: true}, // not probed before
+		{IP: 1<<32 - 1, Ping: true},
+	})
+
+	type change struct {
+		ip    uint32
+		added bool
+	}
+	var changes []change
+	deltas, err := Diff(before, after, 0, 1<<32-1, 24, func(ip uint32, added bool) error {
+		changes = append(changes, change{ip, added})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("TestDiff FAILED: %v", err)
+	}
+	expectedChanges := []change{{1<<24 + 1, false}, {1<<24 + 2, true}, {1<<24 + 256, false}, {1<<32 - 1, true}}
+	if !reflect.DeepEqual(changes, expectedChanges) {
+		t.Errorf("TestDiff FAILED: changes %v (actual) != %v (expected)", changes, expectedChanges)
+	}
+	expectedDeltas := []Delta{
+		{Start: 1 << 24, Prefix: 24, Before: 2, After: 2, Added: 1, Removed: 1},
+		{Start: 1<<24 + 256, Prefix: 24, Before: 1, After: 0, Removed: 1},
+		{Start: 1<<32 - 256, Prefix: 24, Before: 0, After: 1, Added: 1},
+	}
+	if !reflect.DeepEqual(deltas, expectedDeltas) {
+		t.Errorf("TestDiff FAILED: deltas\n%+v (actual)\n%+v (expected)", deltas, expectedDeltas)
+	}
+
+	// range filter and prefix length
+	deltas, _ = Diff(before, after, 1<<24, 1<<24+255, 16, func(uint32, bool) error { return nil })
+	if expected := []Delta{{Start: 1 << 24, Prefix: 16, Before: 2, After: 2, Added: 1, Removed: 1}}; !reflect.DeepEqual(deltas, expected) {
+		t.Errorf("TestDiff FAILED: filtered deltas %+v (actual) != %+v (expected)", deltas, expected)
+	}
+}
@@ -0,0 +1,223 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// Kinds of encoded sets, the smallest one is written
+const (
+	kindArray  = iota // count and sorted values
+	kindRuns          // count and pairs of first and last value of runs
+	kindBitmap        // 65536 bits
+)
+
+const (
+	bitmapWords = 1 << 16 / 64
+	bitmapSize  = bitmapWords * 8
+	maxRuns     = bitmapSize / 4 // runs take more space than bitmap above it
+)
+
+// run is consecutive values [first, last]
+type run struct {
+	first, last uint16
+}
+
+// Set is set of low 16 bits of addresses in /16, kept as runs while they are few and as bitmap otherwise
+// (as containers of roaring bitmaps). Zero value is empty set.
+type Set struct {
+	runs   []run
+	bitmap []uint64
+	len    int
+	last   uint16 // the greatest value
+}
+
+// add adds v, which should be greater than values in set
+func (s *Set) add(v uint16) {
+	s.len++
+	s.last = v
+	if s.bitmap != nil {
+		s.bitmap[v/64] |= 1 << (v % 64)
+		return
+	}
+	if n := len(s.runs); n > 0 && s.runs[n-1].last+1 == v {
+		s.runs[n-1].last = v
+		return
+	}
+	s.runs = append(s.runs, run{v, v})
+	if len(s.runs) > maxRuns {
+		s.toBitmap()
+	}
+}
+
+func (s *Set) toBitmap() {
+	s.bitmap = make([]uint64, bitmapWords)
+	for _, r := range s.runs {
+		for v := int(r.first); v <= int(r.last); v++ {
+			s.bitmap[v/64] |= 1 << (v % 64)
+		}
+	}
+	s.runs = nil
+}
+
+// Len returns number of values in set
+func (s *Set) Len() int {
+	return s.len
+}
+
+// greater returns true if v is greater than values in set
+func (s *Set) greater(v uint16) bool {
+	return s.len == 0 || v > s.last
+}
+
+// Contains returns true if v is in set
+func (s *Set) Contains(v uint16) bool {
+	if s.bitmap != nil {
+		return s.bitmap[v/64]&(1<<(v%64)) != 0
+	}
+	i := sort.Search(len(s.runs), func(i int) bool { return s.runs[i].last >= v })
+	return i < len(s.runs) && s.runs[i].first <= v
+}
+
+// Each passes values to f in ascending order, stops at first error
+func (s *Set) Each(f func(uint16) error) error {
+	if s.bitmap != nil {
+		for i, word := range s.bitmap {
+			for ; word != 0; word &= word - 1 {
+				if err := f(uint16(i*64 + bits.TrailingZeros64(word))); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, r := range s.runs {
+		for v := int(r.first); v <= int(r.last); v++ {
+			if err := f(uint16(v)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// write writes set in the smallest of array, runs and bitmap encodings
+func (s *Set) write(w io.Writer) error {
+	runs := s.runs
+	if s.bitmap != nil {
+		runs = nil
+		s.Each(func(v uint16) error {
+			if n := len(runs); n > 0 && runs[n-1].last+1 == v {
+				runs[n-1].last = v
+			} else {
+				runs = append(runs, run{v, v})
+			}
+			return nil
+		})
+	}
+
+	var buf []byte
+	switch {
+	case 2*s.len <= 4*len(runs) && 2*s.len <= bitmapSize:
+		buf = make([]byte, 5, 5+2*s.len)
+		buf[0] = kindArray
+		binary.BigEndian.PutUint32(buf[1:], uint32(s.len))
+		s.Each(func(v uint16) error {
+			buf = append(buf, byte(v>>8), byte(v))
+			return nil
+		})
+	case 4*len(runs) <= bitmapSize:
+		buf = make([]byte, 5, 5+4*len(runs))
+		buf[0] = kindRuns
+		binary.BigEndian.PutUint32(buf[1:], uint32(len(runs)))
+		for _, r := range runs {
+			buf = append(buf, byte(r.first>>8), byte(r.first), byte(r.last>>8), byte(r.last))
+		}
+	default:
+		buf = make([]byte, 1+bitmapSize)
+		buf[0] = kindBitmap
+		bitmap := s.bitmap
+		if bitmap == nil {
+			tmp := Set{runs: s.runs}
+			tmp.toBitmap()
+			bitmap = tmp.bitmap
+		}
+		for i, word := range bitmap {
+			binary.BigEndian.PutUint64(buf[1+8*i:], word)
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// read reads set written by write
+func (s *Set) read(r io.Reader) error {
+	var kind [1]byte
+	if _, err := io.ReadFull(r, kind[:]); err != nil {
+		return err
+	}
+	*s = Set{}
+
+	if kind[0] == kindBitmap {
+		buf := make([]byte, bitmapSize)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		s.bitmap = make([]uint64, bitmapWords)
+		for i := range s.bitmap {
+			s.bitmap[i] = binary.BigEndian.Uint64(buf[8*i:])
+			s.len += bits.OnesCount64(s.bitmap[i])
+			if s.bitmap[i] != 0 {
+				s.last = uint16(i*64 + 63 - bits.LeadingZeros64(s.bitmap[i]))
+			}
+		}
+		return nil
+	}
+
+	var count [4]byte
+	if _, err := io.ReadFull(r, count[:]); err != nil {
+		return err
+	}
+	n := int(binary.BigEndian.Uint32(count[:]))
+	switch kind[0] {
+	case kindArray:
+		if n > 1<<16 {
+			return fmt.Errorf("array of %d values", n)
+		}
+		buf := make([]byte, 2*n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			v := binary.BigEndian.Uint16(buf[2*i:])
+			if i > 0 && v <= binary.BigEndian.Uint16(buf[2*i-2:]) {
+				return fmt.Errorf("array is not sorted")
+			}
+			s.add(v)
+		}
+	case kindRuns:
+		if n > maxRuns {
+			return fmt.Errorf("%d runs", n)
+		}
+		buf := make([]byte, 4*n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		s.runs = make([]run, n)
+		for i := range s.runs {
+			rn := run{binary.BigEndian.Uint16(buf[4*i:]), binary.BigEndian.Uint16(buf[4*i+2:])}
+			if rn.first > rn.last || i > 0 && int(rn.first) <= int(s.runs[i-1].last)+1 {
+				return fmt.Errorf("runs are not sorted")
+			}
+			s.runs[i] = rn
+			s.len += int(rn.last-rn.first) + 1
+			s.last = rn.last
+		}
+	default:
+		return fmt.Errorf("unknown kind of set %d", kind[0])
+	}
+	return nil
+}
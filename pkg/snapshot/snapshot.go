@@ -0,0 +1,286 @@
+// Package snapshot implements compact binary snapshot of scan round: probed and responsive addresses
+// as sets per /16 with time of scan and optional RTT of responsive ones.
+//
+// Snapshot is header (magic "WPSN", version, flags, creation time, vantage point, number of ranges),
+// ranges ordered by start (start, time of first and last scan, probed and responsive sets,
+// RTT in microseconds per responsive address if flag is set) and CRC-32 (IEEE) of all preceding bytes.
+// Numbers are big-endian, times are unix seconds.
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+// Version of snapshot format written
+const Version = 1
+
+var magic = []byte("WPSN")
+
+// flagRTT means ranges have RTT of responsive addresses
+const flagRTT = 1
+
+// maxVantage is maximum length of vantage point
+const maxVantage = 1<<16 - 1
+
+// Range is results of /16
+type Range struct {
+	Start       uint32    // first address of /16
+	First, Last time.Time // time of first and last scan of addresses, second precision
+	Probed      Set
+	Responsive  Set             // subset of Probed
+	RTT         []time.Duration // of responsive addresses in ascending order, microsecond precision
+}
+
+// Snapshot contains results of vantage point, ranges without probed addresses are not kept
+type Snapshot struct {
+	Created time.Time
+	Vantage string
+	HasRTT  bool
+	Ranges  []*Range // ordered by Start
+}
+
+// New returns empty snapshot, with RTT of responsive addresses if rtt is true
+func New(vantage string, rtt bool) *Snapshot {
+	return &Snapshot{Created: time.Now(), Vantage: vantage, HasRTT: rtt}
+}
+
+// Add adds result, results should be added in ascending order of addresses
+func (s *Snapshot) Add(t types.Task) error {
+	start := t.IP &^ 0xffff
+	var r *Range
+	if n := len(s.Ranges); n > 0 && s.Ranges[n-1].Start == start {
+		r = s.Ranges[n-1]
+	} else if n > 0 && s.Ranges[n-1].Start > start {
+		return fmt.Errorf("result of %d is added after results of greater addresses", t.IP)
+	} else {
+		r = &Range{Start: start}
+		s.Ranges = append(s.Ranges, r)
+	}
+
+	v := uint16(t.IP)
+	if !r.Probed.greater(v) {
+		return fmt.Errorf("result of %d is added after results of greater addresses", t.IP)
+	}
+	r.Probed.add(v)
+	if t.Ping {
+		r.Responsive.add(v)
+		if s.HasRTT {
+			r.RTT = append(r.RTT, t.RTT)
+		}
+	}
+	if !t.Time.IsZero() {
+		when := t.Time.Truncate(time.Second)
+		if r.First.IsZero() || when.Before(r.First) {
+			r.First = when
+		}
+		if when.After(r.Last) {
+			r.Last = when
+		}
+	}
+	return nil
+}
+
+// Counts returns number of probed and responsive addresses
+func (s *Snapshot) Counts() (probed, responsive int) {
+	for _, r := range s.Ranges {
+		probed += r.Probed.Len()
+		responsive += r.Responsive.Len()
+	}
+	return probed, responsive
+}
+
+// Results passes results of probed addresses to f in ascending order, stops at first error.
+// Results get vantage point of snapshot and time of last scan of their range.
+func (s *Snapshot) Results(f func(types.Task) error) error {
+	for _, r := range s.Ranges {
+		responsive := 0
+		err := r.Probed.Each(func(v uint16) error {
+			t := types.Task{IP: r.Start | uint32(v), Vantage: s.Vantage, Time: r.Last}
+			if r.Responsive.Contains(v) {
+				t.Ping = true
+				if s.HasRTT {
+					t.RTT = r.RTT[responsive]
+				}
+				responsive++
+			}
+			return f(t)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write writes snapshot to w
+func (s *Snapshot) Write(w io.Writer) error {
+	if len(s.Vantage) > maxVantage {
+		return fmt.Errorf("vantage point is longer than %d bytes", maxVantage)
+	}
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	var flags uint16
+	if s.HasRTT {
+		flags |= flagRTT
+	}
+	header := append([]byte{}, magic...)
+	header = appendUint(header, Version, 2)
+	header = appendUint(header, uint64(flags), 2)
+	header = appendUint(header, uint64(s.Created.Unix()), 8)
+	header = appendUint(header, uint64(len(s.Vantage)), 2)
+	header = append(header, s.Vantage...)
+	header = appendUint(header, uint64(len(s.Ranges)), 4)
+	bw.Write(header)
+
+	for _, r := range s.Ranges {
+		buf := appendUint(nil, uint64(r.Start>>16), 2)
+		buf = appendUint(buf, uint64(unix(r.First)), 8)
+		buf = appendUint(buf, uint64(unix(r.Last)), 8)
+		bw.Write(buf)
+		if err := r.Probed.write(bw); err != nil {
+			return err
+		}
+		if err := r.Responsive.write(bw); err != nil {
+			return err
+		}
+		if s.HasRTT {
+			buf = make([]byte, 0, 4*len(r.RTT))
+			for _, rtt := range r.RTT {
+				buf = appendUint(buf, uint64(rtt/time.Microsecond), 4)
+			}
+			bw.Write(buf)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	_, err := w.Write(appendUint(nil, uint64(crc.Sum32()), 4))
+	return err
+}
+
+// Read reads snapshot written by Write, checksum and format version are verified
+func Read(r io.Reader) (s *Snapshot, err error) {
+	crc := crc32.NewIEEE()
+	br := bufio.NewReader(r)
+	tr := io.TeeReader(br, crc)
+	read := func(n int) ([]byte, error) {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(tr, buf)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf, err
+	}
+
+	header, err := read(len(magic) + 2 + 2 + 8 + 2)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(magic)], magic) {
+		return nil, fmt.Errorf("not a snapshot")
+	}
+	header = header[len(magic):]
+	if version := binary.BigEndian.Uint16(header); version != Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d (should be %d)", version, Version)
+	}
+	s = &Snapshot{
+		HasRTT:  binary.BigEndian.Uint16(header[2:])&flagRTT != 0,
+		Created: time.Unix(int64(binary.BigEndian.Uint64(header[4:])), 0),
+	}
+	vantage, err := read(int(binary.BigEndian.Uint16(header[12:])))
+	if err != nil {
+		return nil, err
+	}
+	s.Vantage = string(vantage)
+
+	buf, err := read(4)
+	if err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(buf)
+	if n > 1<<16 {
+		return nil, fmt.Errorf("%d ranges", n)
+	}
+	for i := 0; i < int(n); i++ {
+		if buf, err = read(2 + 8 + 8); err != nil {
+			return nil, err
+		}
+		r := &Range{
+			Start: uint32(binary.BigEndian.Uint16(buf)) << 16,
+			First: fromUnix(int64(binary.BigEndian.Uint64(buf[2:]))),
+			Last:  fromUnix(int64(binary.BigEndian.Uint64(buf[10:]))),
+		}
+		if i > 0 && r.Start <= s.Ranges[i-1].Start {
+			return nil, fmt.Errorf("ranges are not sorted")
+		}
+		if err = r.Probed.read(tr); err != nil {
+			return nil, err
+		}
+		if err = r.Responsive.read(tr); err != nil {
+			return nil, err
+		}
+		if s.HasRTT {
+			if buf, err = read(4 * r.Responsive.Len()); err != nil {
+				return nil, err
+			}
+			r.RTT = make([]time.Duration, r.Responsive.Len())
+			for j := range r.RTT {
+				r.RTT[j] = time.Duration(binary.BigEndian.Uint32(buf[4*j:])) * time.Microsecond
+			}
+		}
+		s.Ranges = append(s.Ranges, r)
+	}
+
+	sum := crc.Sum32()
+	trailer := make([]byte, 4)
+	if _, err = io.ReadFull(br, trailer); err != nil {
+		return nil, fmt.Errorf("checksum is missing: %v", err)
+	}
+	if binary.BigEndian.Uint32(trailer) != sum {
+		return nil, fmt.Errorf("checksum mismatch, snapshot is corrupted")
+	}
+	// responsive addresses are checked after checksum, so corruption is reported as such
+	for _, r := range s.Ranges {
+		if err = r.Responsive.Each(func(v uint16) error {
+			if !r.Probed.Contains(v) {
+				return fmt.Errorf("responsive address %d is not probed", r.Start|uint32(v))
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func appendUint(buf []byte, v uint64, size int) []byte {
+	for i := size - 1; i >= 0; i-- {
+		buf = append(buf, byte(v>>(8*i)))
+	}
+	return buf
+}
+
+// unix returns unix time, 0 for zero time
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// fromUnix returns time of unix time, zero time for 0
+func fromUnix(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
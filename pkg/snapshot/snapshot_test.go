@@ -0,0 +1,127 @@
+package snapshot
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func TestSetEncodings(t *testing.T) {
+	tests := []struct {
+		name   string
+		values func(add func(uint16))
+		kind   byte
+	}{
+		{"sparse", func(add func(uint16)) {
+			for v := 0; v < 1<<16; v += 1000 {
+				add(uint16(v))
+			}
+		}, kindArray},
+		{"contiguous", func(add func(uint16)) {
+			for v := 100; v < 60000; v++ {
+				add(uint16(v))
+			}
+		}, kindRuns},
+		{"dense", func(add func(uint16)) {
+			for v := 0; v < 1<<16; v += 2 {
+				add(uint16(v))
+			}
+		}, kindBitmap},
+	}
+
+	for _, test := range tests {
+		var set Set
+		var expected []uint16
+		test.values(func(v uint16) {
+			set.add(v)
+			expected = append(expected, v)
+		})
+
+		var buf bytes.Buffer
+		if err := set.write(&buf); err != nil {
+			t.Fatalf("%s FAILED: %v", test.name, err)
+		}
+		if kind := buf.Bytes()[0]; kind != test.kind {
+			t.Errorf("%s FAILED: kind %d (actual) != %d (expected)", test.name, kind, test.kind)
+		}
+		var decoded Set
+		if err := decoded.read(&buf); err != nil {
+			t.Fatalf("%s FAILED: %v", test.name, err)
+		}
+		var actual []uint16
+		decoded.Each(func(v uint16) error {
+			actual = append(actual, v)
+			return nil
+		})
+		if !reflect.DeepEqual(actual, expected) || decoded.Len() != len(expected) || !decoded.greater(expected[len(expected)-1]+1) {
+			t.Errorf("%s FAILED: %d values decoded (actual) != %d (expected)", test.name, len(actual), len(expected))
+		}
+		if decoded.Contains(1) {
+			t.Errorf("%s FAILED: contains value not added", test.name)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	when := time.Unix(1609459200, 0)
+	results := []types.Task{
+		{IP: 1 << 24, Time: when},
+		{IP: 1<<24 + 1, Ping: true, RTT: 1500 * time.Microsecond, Time: when.Add(time.Hour)},
+		{IP: 1<<32 - 1, Ping: true, RTT: 20 * time.Millisecond, Time: when},
+	}
+	s := New("eu", true)
+	for _, r := range results {
+		if err := s.Add(r); err != nil {
+			t.Fatalf("TestSnapshot FAILED: add: %v", err)
+		}
+	}
+	if err := s.Add(types.Task{IP: 1 << 24}); err == nil {
+		t.Errorf("TestSnapshot FAILED: result added out of order")
+	}
+
+	var buf bytes.Buffer
+	if err := s.Write(&buf); err != nil {
+		t.Fatalf("TestSnapshot FAILED: write: %v", err)
+	}
+	data := buf.Bytes()
+	restored, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("TestSnapshot FAILED: read: %v", err)
+	}
+	if probed, responsive := restored.Counts(); probed != 3 || responsive != 2 || restored.Vantage != "eu" || !restored.HasRTT {
+		t.Errorf("TestSnapshot FAILED: %d probed, %d responsive, vantage %q", probed, responsive, restored.Vantage)
+	}
+
+	// results get time of last scan of their /16
+	expected := []types.Task{
+		{IP: 1 << 24, Vantage: "eu", Time: when.Add(time.Hour)},
+		{IP: 1<<24 + 1, Vantage: "eu", Ping: true, RTT: 1500 * time.Microsecond, Time: when.Add(time.Hour)},
+		{IP: 1<<32 - 1, Vantage: "eu", Ping: true, RTT: 20 * time.Millisecond, Time: when},
+	}
+	var actual []types.Task
+	restored.Results(func(t types.Task) error {
+		actual = append(actual, t)
+		return nil
+	})
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("TestSnapshot FAILED:\n%+v (actual)\n%+v (expected)", actual, expected)
+	}
+
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-6] ^= 1
+	if _, err := Read(bytes.NewReader(corrupted)); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("TestSnapshot FAILED: corrupted snapshot read with error %v", err)
+	}
+	if _, err := Read(bytes.NewReader(data[:len(data)-10])); err == nil {
+		t.Errorf("TestSnapshot FAILED: truncated snapshot read")
+	}
+	future := append([]byte{}, data...)
+	future[5] = 2
+	if _, err := Read(bytes.NewReader(future)); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("TestSnapshot FAILED: snapshot of unknown version read with error %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+// Package spool implements on-disk queue of result batches
+// which could not be saved to database.
+package spool
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"expvar"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+const (
+	magic      = "WPS1" // payload is types.Batch
+	headerSize = 16     // magic, CRC-32C of payload, payload length
+
+	batchExt   = ".batch"
+	tmpExt     = ".tmp"
+	corruptExt = ".corrupt"
+)
+
+// ErrQuota is returned when batch does not fit into disk quota
+var ErrQuota = errors.New("spool quota exceeded")
+
+// ErrCorrupt is returned for batch file with wrong header or checksum
+var ErrCorrupt = errors.New("spool batch corrupted")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Metrics are published with expvar as "spool" map.
+// Gauges (batches, bytes) describe the last opened spool.
+var (
+	metrics         = expvar.NewMap("spool")
+	metricBatches   = new(expvar.Int) // batches waiting for replay
+	metricBytes     = new(expvar.Int) // size of waiting batches
+	metricSpooled   = new(expvar.Int) // batches written
+	metricReplayed  = new(expvar.Int) // batches saved to database
+	metricDropped   = new(expvar.Int) // batches not written due to quota
+	metricCorrupted = new(expvar.Int) // batches failed checksum verification
+)
+
+func init() {
+	metrics.Set("batches", metricBatches)
+	metrics.Set("bytes", metricBytes)
+	metrics.Set("spooled", metricSpooled)
+	metrics.Set("replayed", metricReplayed)
+	metrics.Set("dropped", metricDropped)
+	metrics.Set("corrupted", metricCorrupted)
+}
+
+type entry struct {
+	name string
+	size int64
+}
+
+// Spool keeps every batch in separate file named by sequence number.
+// Files are written to temporary name, synced and renamed, so batch is either
+// complete or absent after crash. Batches are replayed in order they were written.
+type Spool struct {
+	dir   string
+	quota int64
+
+	mtx     sync.Mutex
+	entries []entry
+	size    int64
+	seq     uint64
+}
+
+// Open creates spool directory if needed and loads batches left from previous run.
+// Quota limits total size of batch files in bytes (0 - unlimited).
+func Open(dir string, quota int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir, quota: quota}
+	for _, f := range files {
+		name := f.Name()
+		switch {
+		case strings.HasSuffix(name, tmpExt):
+			os.Remove(filepath.Join(dir, name)) // interrupted write
+		case strings.HasSuffix(name, batchExt):
+			var seq uint64
+			if _, err := fmt.Sscanf(name, "%d"+batchExt, &seq); err != nil {
+				continue
+			}
+			if seq > s.seq {
+				s.seq = seq
+			}
+			s.entries = append(s.entries, entry{name: name, size: f.Size()})
+			s.size += f.Size()
+		}
+	}
+	// names are zero-padded, so lexical order is sequence order
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].name < s.entries[j].name })
+	s.updateGauges()
+	return s, nil
+}
+
+// Len returns amount of batches waiting for replay
+func (s *Spool) Len() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.entries)
+}
+
+// Size returns total size of batches waiting for replay
+func (s *Spool) Size() int64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.size
+}
+
+// Put writes batch to the end of spool
+func (s *Spool) Put(batch types.Batch) error {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(batch); err != nil {
+		return err
+	}
+	b := make([]byte, headerSize, headerSize+payload.Len())
+	copy(b, magic)
+	binary.BigEndian.PutUint32(b[4:], crc32.Checksum(payload.Bytes(), crcTable))
+	binary.BigEndian.PutUint64(b[8:], uint64(payload.Len()))
+	b = append(b, payload.Bytes()...)
+
+	s.mtx.Lock()
+	if s.quota > 0 && s.size+int64(len(b)) > s.quota {
+		s.mtx.Unlock()
+		metricDropped.Add(1)
+		return ErrQuota
+	}
+	// space is reserved before writing, so concurrent writers do not exceed quota
+	s.seq++
+	name := fmt.Sprintf("%020d%s", s.seq, batchExt)
+	s.size += int64(len(b))
+	s.mtx.Unlock()
+
+	if err := writeFile(filepath.Join(s.dir, name), b); err != nil {
+		s.mtx.Lock()
+		s.size -= int64(len(b))
+		s.mtx.Unlock()
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	// keep order by sequence even if writes finished out of order
+	i := sort.Search(len(s.entries), func(i int) bool { return s.entries[i].name > name })
+	s.entries = append(s.entries, entry{})
+	copy(s.entries[i+1:], s.entries[i:])
+	s.entries[i] = entry{name: name, size: int64(len(b))}
+	metricSpooled.Add(1)
+	s.updateGauges()
+	return nil
+}
+
+// writeFile writes data to temporary file, syncs it and renames to name
+func writeFile(name string, data []byte) error {
+	tmp := name + tmpExt
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// Replay passes batches to save in order and removes saved ones.
+// It stops at first save error, so the batch is retried next time.
+// Corrupted batches are renamed with .corrupt suffix and skipped.
+// Returns amount of saved batches. Should not be called concurrently.
+func (s *Spool) Replay(save func(types.Batch) error) (n int, err error) {
+	for {
+		s.mtx.Lock()
+		if len(s.entries) == 0 {
+			s.mtx.Unlock()
+			return n, nil
+		}
+		e := s.entries[0]
+		s.mtx.Unlock()
+
+		path := filepath.Join(s.dir, e.name)
+		batch, err := readFile(path)
+		if err == ErrCorrupt {
+			metricCorrupted.Add(1)
+			err = os.Rename(path, path+corruptExt)
+		} else if err == nil {
+			if err = save(batch); err != nil {
+				return n, err
+			}
+			n++
+			metricReplayed.Add(1)
+			err = os.Remove(path)
+		}
+		if err != nil {
+			return n, err
+		}
+
+		s.mtx.Lock()
+		// entry written out of order could be inserted before the replayed one meanwhile
+		for i := range s.entries {
+			if s.entries[i].name == e.name {
+				s.entries = append(s.entries[:i], s.entries[i+1:]...)
+				break
+			}
+		}
+		s.size -= e.size
+		s.updateGauges()
+		s.mtx.Unlock()
+	}
+}
+
+// readFile reads batch and verifies its checksum
+func readFile(name string) (batch types.Batch, err error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return batch, err
+	}
+	if len(b) < headerSize || string(b[:4]) != magic || binary.BigEndian.Uint64(b[8:]) != uint64(len(b)-headerSize) {
+		return batch, ErrCorrupt
+	}
+	payload := b[headerSize:]
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(b[4:]) {
+		return batch, ErrCorrupt
+	}
+	if err = gob.NewDecoder(bytes.NewReader(payload)).Decode(&batch); err != nil {
+		return types.Batch{}, ErrCorrupt
+	}
+	return batch, nil
+}
+
+// updateGauges should be called with mutex held
+func (s *Spool) updateGauges() {
+	metricBatches.Set(int64(len(s.entries)))
+	metricBytes.Set(s.size)
+}
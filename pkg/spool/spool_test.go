@@ -0,0 +1,130 @@
+package spool
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func TestPutReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Cannot open spool: %v", err)
+	}
+
+	id := types.BatchID{Worker: "worker-1", Started: time.Unix(1600000000, 42), Seq: 1}
+	batches := []types.Batch{
+		{ID: id, Results: types.Tasks{{IP: 1, Ping: true, Probe: "echo", Replies: 1}}},
+		{Results: types.Tasks{{IP: 2, Vantage: "eu"}, {IP: 3, ICMPType: 3, ICMPCode: 1, Router: 42}}},
+		{Results: types.Tasks{{IP: 4}}},
+	}
+	for i, b := range batches {
+		if err := s.Put(b); err != nil {
+			t.Fatalf("Batch %d FAILED: cannot put: %v", i, err)
+		}
+	}
+	if s.Len() != 3 || s.Size() == 0 {
+		t.Errorf("FAILED: wrong spool length %d or size %d", s.Len(), s.Size())
+	}
+
+	// save fails on second batch, first one should not be replayed again
+	var saved []types.Batch
+	failing := func(b types.Batch) error {
+		if len(saved) == 1 {
+			return errors.New("some error")
+		}
+		saved = append(saved, b)
+		return nil
+	}
+	if n, err := s.Replay(failing); n != 1 || err == nil {
+		t.Errorf("FAILED: replay should stop on error: %d, %v", n, err)
+	}
+
+	// batches left are replayed in order after restart
+	s, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Cannot reopen spool: %v", err)
+	}
+	if s.Len() != 2 {
+		t.Errorf("FAILED: %d batches left instead of 2", s.Len())
+	}
+	save := func(b types.Batch) error {
+		saved = append(saved, b)
+		return nil
+	}
+	if n, err := s.Replay(save); n != 2 || err != nil {
+		t.Errorf("FAILED: replay: %d, %v", n, err)
+	}
+	if len(saved) != 3 || !saved[0].ID.Started.Equal(id.Started) {
+		t.Fatalf("FAILED: replayed %+v instead of %+v", saved, batches)
+	}
+	saved[0].ID.Started = id.Started // gob drops monotonic clock and location
+	if !reflect.DeepEqual(saved, batches) {
+		t.Errorf("FAILED: replayed %+v instead of %+v", saved, batches)
+	}
+	if s.Len() != 0 || s.Size() != 0 {
+		t.Errorf("FAILED: spool should be empty: %d, %d", s.Len(), s.Size())
+	}
+
+	// sequence continues after restart
+	s.Put(batches[0])
+	s, _ = Open(dir, 0)
+	if s.seq != 4 {
+		t.Errorf("FAILED: sequence %d after restart instead of 4", s.seq)
+	}
+}
+
+func TestCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 0)
+	s.Put(types.Batch{Results: types.Tasks{{IP: 1}}})
+	s.Put(types.Batch{Results: types.Tasks{{IP: 2}}})
+
+	// flip last byte of first batch, leave temporary file of interrupted write
+	name := filepath.Join(dir, s.entries[0].name)
+	b, _ := ioutil.ReadFile(name)
+	b[len(b)-1] ^= 0xff
+	ioutil.WriteFile(name, b, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "00000000000000000003.batch.tmp"), []byte("partial"), 0644)
+
+	s, _ = Open(dir, 0)
+	var saved types.Tasks
+	n, err := s.Replay(func(b types.Batch) error {
+		saved = append(saved, b.Results...)
+		return nil
+	})
+	if n != 1 || err != nil || len(saved) != 1 || saved[0].IP != 2 {
+		t.Errorf("FAILED: corrupted batch should be skipped: %d, %v, %+v", n, err, saved)
+	}
+	if _, err := os.Stat(name + corruptExt); err != nil {
+		t.Errorf("FAILED: corrupted batch should be kept: %v", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("FAILED: only corrupted batch should be left, got %d files", len(files))
+	}
+}
+
+func TestQuota(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 0)
+	s.Put(types.Batch{Results: types.Tasks{{IP: 1}}})
+	size := s.Size()
+
+	s, _ = Open(dir, 2*size)
+	if err := s.Put(types.Batch{Results: types.Tasks{{IP: 2}}}); err != nil {
+		t.Errorf("FAILED: batch fits into quota: %v", err)
+	}
+	if err := s.Put(types.Batch{Results: types.Tasks{{IP: 3}}}); err != ErrQuota {
+		t.Errorf("FAILED: quota error expected, got %v", err)
+	}
+	if s.Len() != 2 {
+		t.Errorf("FAILED: %d batches instead of 2", s.Len())
+	}
+}
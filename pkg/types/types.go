@@ -1,10 +1,109 @@
 package types
 
+import (
+	"fmt"
+	"time"
+)
+
+// Anomaly flags of replies
+const (
+	AnomalyDuplicate     = 1 << iota // more than one reply to single probe
+	AnomalyForeignSource             // reply came from address other than probed
+)
+
 // Task contains info about a task
 type Task struct {
-	IP   uint32
-	Ping bool
+	IP      uint32
+	Vantage string // vantage point of worker, empty if single
+	Ping    bool
+	Probe   string // probe type which first elicited response
+
+	// ICMP error (e.g. destination unreachable) elicited by probes, zero type if none
+	ICMPType uint8
+	ICMPCode uint8
+	Router   uint32 // address of host sent ICMP error
+
+	Replies   uint16        // replies to successful probe, amplification factor
+	Anomalies uint8         // Anomaly* flags
+	ReplyFrom uint32        // first reply source other than IP
+	RTT       time.Duration // time to first reply of successful probe
+
+	Time   time.Time // when host was probed, zero if just now
+	Source string    // scanner which produced result if not worldping, e.g. "zmap"
 }
 
 // Tasks is an slice of tasks
 type Tasks []Task
+
+// BatchID identifies batch of results: worker, time it started and sequence number
+// of batch since start. Zero ID (Seq starts from 1) means batch is not identified.
+type BatchID struct {
+	Worker  string
+	Started time.Time
+	Seq     uint64
+}
+
+// IsZero returns true if batch is not identified
+func (id BatchID) IsZero() bool {
+	return id.Seq == 0
+}
+
+// String returns ID as worker/started/seq
+func (id BatchID) String() string {
+	return fmt.Sprintf("%s/%d/%d", id.Worker, id.Started.UnixNano(), id.Seq)
+}
+
+// Batch is results saved at once. Batch with the same ID is saved only once.
+type Batch struct {
+	ID      BatchID
+	Results Tasks
+}
+
+// Hop is single hop of traceroute, Router is zero if nobody answered
+type Hop struct {
+	TTL    uint8
+	Router uint32
+	RTT    time.Duration
+}
+
+// Trace contains traceroute to the target
+type Trace struct {
+	Target uint32
+	Method string
+	Hops   []Hop
+}
+
+// PTR is reverse DNS record of IP, Hostname is empty if there is none
+type PTR struct {
+	IP       uint32
+	Hostname string
+}
+
+// Rollup is aggregate of results in prefix (/24 or /16) of Prefix length starting at Start.
+// Addresses are counted once if probed from several vantage points, RTT is mean of responsive ones.
+type Rollup struct {
+	Start       uint32
+	Prefix      int
+	Probed      int
+	Responsive  int
+	RTT         time.Duration
+	LastScanned time.Time
+}
+
+// Rescan is prefix queued for priority rescan.
+// Higher priority is served first, zero Deadline means no deadline.
+type Rescan struct {
+	ID       int64
+	Start    uint32
+	Prefix   uint8 // prefix length
+	Priority int
+	Deadline time.Time
+	Created  time.Time
+	Taken    time.Time // zero until worker picks it up
+	Done     time.Time // zero until all addresses are probed
+}
+
+// End returns last address of prefix
+func (r Rescan) End() uint32 {
+	return uint32(uint64(r.Start) + 1<<(32-uint(r.Prefix)) - 1)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nanorobocop/worldping/db"
+	"github.com/nanorobocop/worldping/pkg/rdns"
+	"github.com/nanorobocop/worldping/pkg/types"
+	"github.com/nanorobocop/worldping/pkg/utils"
+)
+
+const rdnsBatchSize = 1000
+
+var rdnsResolver = os.Getenv("RDNS_RESOLVER") // host:port, system resolver if empty
+var rdnsConcurrency, _ = strconv.Atoi(getEnv("RDNS_CONCURRENCY", "50"))
+var rdnsRate, _ = strconv.Atoi(getEnv("RDNS_RATE", "100")) // lookups per second
+var rdnsMaxAge, rdnsMaxAgeErr = time.ParseDuration(getEnv("RDNS_MAX_AGE", "720h"))
+
+// PTRResolver interface
+type PTRResolver interface {
+	Lookup(context.Context, uint32) (string, error)
+}
+
+// rdns looks up PTR records of responsive IPs and stores hostnames
+func (env *envStruct) rdns() {
+	store, ok := env.dbConn.(db.PTRStore)
+	if !ok {
+		env.log.Fatalf("Database does not support storing PTR records")
+	}
+
+	if rdnsConcurrency <= 0 {
+		env.log.Fatalf("Wrong value RDNS_CONCURRENCY=%v (should be positive)", rdnsConcurrency)
+	}
+	// ticker interval should be at least 1ns
+	if rdnsRate <= 0 || rdnsRate > int(time.Second) {
+		env.log.Fatalf("Wrong value RDNS_RATE=%v (should be from 1 to %d)", rdnsRate, int(time.Second))
+	}
+	if rdnsMaxAgeErr != nil || rdnsMaxAge < 0 {
+		env.log.Fatalf("Wrong value RDNS_MAX_AGE: %v", getEnv("RDNS_MAX_AGE", ""))
+	}
+
+	env.enrich(store, rdns.New(rdnsResolver, 5*time.Second))
+}
+
+// enrich looks up PTR records range by range (/8).
+// Records are saved in batches and IPs looked up during RDNS_MAX_AGE are skipped,
+// so job could be interrupted and restarted at any time.
+// IPs with failed lookups are not saved and retried at next run.
+func (env *envStruct) enrich(store db.PTRStore, resolver PTRResolver) {
+	limiter := time.NewTicker(time.Second / time.Duration(rdnsRate))
+	defer limiter.Stop()
+
+	ptrCh := make(chan types.PTR)
+	done := make(chan struct{})
+	go env.savePTRs(store, ptrCh, done)
+
+	var wg sync.WaitGroup
+	guard := make(chan struct{}, rdnsConcurrency)
+
+loop:
+	for prefix := 0; prefix < 256; prefix++ {
+		start := uint32(prefix) << 24
+		end := start + (1 << 24) - 1
+
+		targets, err := store.GetPTRTargets(start, end, rdnsMaxAge)
+		if err != nil {
+			env.log.Errorf("Cannot get PTR targets for range %s:%s: %v", utils.IPToStr(start), utils.IPToStr(end), err)
+			continue
+		}
+		if len(targets) > 0 {
+			env.log.Noticef("Looking up %d PTR records in range %s:%s", len(targets), utils.IPToStr(start), utils.IPToStr(end))
+		}
+
+		for _, ip := range targets {
+			select {
+			case <-limiter.C:
+			case <-env.ctx.Done():
+				env.log.Noticef("Received signal for shutdown.")
+				break loop
+			}
+			guard <- struct{}{}
+
+			wg.Add(1)
+			go func(ip uint32) {
+				defer wg.Done()
+				hostname, err := resolver.Lookup(env.ctx, ip)
+				if err != nil {
+					env.log.Debugf("rdns: %s : %v", utils.IPToStr(ip), err)
+				} else {
+					ptrCh <- types.PTR{IP: ip, Hostname: hostname}
+				}
+				<-guard
+			}(ip)
+		}
+	}
+
+	wg.Wait()
+	close(ptrCh)
+	<-done
+	env.log.Notice("PTR lookups finished")
+}
+
+// savePTRs commits records in batches until channel is closed
+func (env *envStruct) savePTRs(store db.PTRStore, ptrCh chan types.PTR, done chan struct{}) {
+	defer close(done)
+
+	save := func(ptrs []types.PTR) {
+		env.log.Noticef("Saving %d PTR records to DB", len(ptrs))
+		if err := store.SavePTR(ptrs); err != nil {
+			env.log.Errorf("Problem at saving PTR records to database: %s", err)
+		}
+	}
+
+	ptrs := make([]types.PTR, 0, rdnsBatchSize)
+	for ptr := range ptrCh {
+		ptrs = append(ptrs, ptr)
+		if len(ptrs) == rdnsBatchSize {
+			save(ptrs)
+			ptrs = make([]types.PTR, 0, rdnsBatchSize)
+		}
+	}
+	if len(ptrs) != 0 {
+		save(ptrs)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/apsdehal/go-logger"
+	"github.com/golang/mock/gomock"
+	"github.com/nanorobocop/worldping/mocks"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+type mockResolver map[uint32]string
+
+func (r mockResolver) Lookup(ctx context.Context, ip uint32) (string, error) {
+	hostname, ok := r[ip]
+	if !ok {
+		return "", errors.New("some error")
+	}
+	return hostname, nil
+}
+
+func TestEnrich(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockStore := mocks.NewMockPTRStore(mockCtrl)
+	mockEnv := &envStruct{ctx: context.Background()}
+	mockEnv.log, _ = logger.New("worldping", 0, os.Stdout)
+
+	resolver := mockResolver{
+		134744072: "dns.google", // 8.8.8.8
+		134743044: "",           // 8.8.4.4, no PTR
+	}
+
+	mockStore.EXPECT().GetPTRTargets(uint32(8<<24), uint32(9<<24-1), rdnsMaxAge).Return([]uint32{134744072, 134743044, 134744073}, nil).Times(1)
+	mockStore.EXPECT().GetPTRTargets(uint32(9<<24), uint32(10<<24-1), rdnsMaxAge).Return(nil, errors.New("some error")).Times(1)
+	mockStore.EXPECT().GetPTRTargets(gomock.Any(), gomock.Any(), rdnsMaxAge).Return(nil, nil).Times(254)
+	mockStore.EXPECT().SavePTR(gomock.Any()).DoAndReturn(func(ptrs []types.PTR) error {
+		if len(ptrs) != 2 {
+			t.Errorf("TEST FAILED: expected 2 records, actual %+v", ptrs)
+		}
+		for _, ptr := range ptrs {
+			if ptr.Hostname != resolver[ptr.IP] {
+				t.Errorf("TEST FAILED: wrong record %+v", ptr)
+			}
+		}
+		return nil
+	}).Times(1)
+
+	mockEnv.enrich(mockStore, resolver)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nanorobocop/worldping/db"
+	"github.com/nanorobocop/worldping/pkg/types"
+	"github.com/nanorobocop/worldping/pkg/utils"
+)
+
+// rescanCheck is how often (in addresses) sweep checks rescan queue
+const rescanCheck = 1 << 16
+
+// sendTasks sends addresses from range [start, end] to probing.
+// If queue is not nil, it is drained every rescanCheck addresses, so queued
+// prefixes are rescanned without waiting for the end of the range.
+// Returns false and next address which was not sent on shutdown or drain.
+func (env *envStruct) sendTasks(tasksCh chan types.Task, start, end uint32, queue db.RescanQueue) (next uint32, ok bool) {
+	for curIP := start; ; curIP++ {
+		if queue != nil && (curIP-start)%rescanCheck == 0 && !env.drainRescans(queue, tasksCh) {
+			return curIP, false
+		}
+		select {
+		case tasksCh <- types.Task{IP: curIP}:
+			atomic.StoreUint32(&env.progress, curIP)
+			env.log.Debugf("getTasks: Sending task with ip=%d", curIP)
+		case <-env.drainCh:
+			return curIP, false
+		case <-env.ctx.Done():
+			return curIP, false
+		}
+		if curIP == end {
+			return curIP + 1, true
+		}
+	}
+}
+
+// drainRescans sends addresses of queued prefixes to probing until queue is empty.
+// Returns false on shutdown or drain, prefix interrupted by drain is given back to queue.
+func (env *envStruct) drainRescans(queue db.RescanQueue, tasksCh chan types.Task) bool {
+	for {
+		rescan, ok, err := queue.NextRescan()
+		if err != nil {
+			env.log.Errorf("Cannot get prefix from rescan queue: %v", err)
+			return true
+		}
+		if !ok {
+			return true
+		}
+
+		env.log.Noticef("Rescanning %s/%d (priority %d)", utils.IPToStr(rescan.Start), rescan.Prefix, rescan.Priority)
+		if _, ok := env.sendTasks(tasksCh, rescan.Start, rescan.End(), nil); !ok {
+			if env.isDraining() {
+				if err := queue.ReleaseRescan(rescan.ID); err != nil {
+					env.log.Errorf("Cannot release %s/%d: %v", utils.IPToStr(rescan.Start), rescan.Prefix, err)
+				}
+			}
+			return false
+		}
+		if err := queue.FinishRescan(rescan.ID); err != nil {
+			env.log.Errorf("Cannot mark %s/%d as rescanned: %v", utils.IPToStr(rescan.Start), rescan.Prefix, err)
+		}
+	}
+}
+
+// rescan manages rescan queue: "rescan add PREFIX [-priority N] [-deadline DURATION]" or "rescan list"
+func (env *envStruct) rescan(args []string) {
+	queue, ok := env.dbConn.(db.RescanQueue)
+	if !ok {
+		env.log.Fatalf("Rescan queue is not supported by database")
+	}
+
+	if len(args) == 0 {
+		env.log.Fatalf("Rescan subcommand is missing (should be add or list)")
+	}
+	switch args[0] {
+	case "add":
+		rescan, err := parseRescan(args[1:], time.Now())
+		if err != nil {
+			env.log.Fatalf("Wrong rescan arguments: %v", err)
+		}
+		id, err := queue.EnqueueRescan(rescan)
+		if err != nil {
+			env.log.Fatalf("Cannot enqueue rescan: %v", err)
+		}
+		env.log.Noticef("Enqueued %s/%d with ID %d", utils.IPToStr(rescan.Start), rescan.Prefix, id)
+	case "list":
+		rescans, err := queue.GetRescans()
+		if err != nil {
+			env.log.Fatalf("Cannot get rescan queue: %v", err)
+		}
+		printRescans(os.Stdout, rescans, time.Now())
+	default:
+		env.log.Fatalf("Unknown rescan subcommand %q (should be add or list)", args[0])
+	}
+}
+
+// parseRescan parses "PREFIX [-priority N] [-deadline DURATION]", deadline is relative to now
+func parseRescan(args []string, now time.Time) (rescan types.Rescan, err error) {
+	fs := flag.NewFlagSet("rescan add", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	priority := fs.Int("priority", 0, "higher priority is rescanned first")
+	deadline := fs.Duration("deadline", 0, "skip rescan if not started in time (0 - no deadline)")
+
+	if len(args) == 0 {
+		return rescan, fmt.Errorf("prefix is missing")
+	}
+	_, ipNet, err := net.ParseCIDR(args[0])
+	if err != nil {
+		return rescan, err
+	}
+	ip := ipNet.IP.To4()
+	if ip == nil {
+		return rescan, fmt.Errorf("%s is not IPv4 prefix", args[0])
+	}
+	if err = fs.Parse(args[1:]); err != nil {
+		return rescan, err
+	}
+	if *deadline < 0 {
+		return rescan, fmt.Errorf("negative deadline %v", *deadline)
+	}
+
+	ones, _ := ipNet.Mask.Size()
+	rescan = types.Rescan{Start: binary.BigEndian.Uint32(ip), Prefix: uint8(ones), Priority: *priority}
+	if *deadline > 0 {
+		rescan.Deadline = now.Add(*deadline)
+	}
+	return rescan, nil
+}
+
+// printRescans writes table of rescan queue
+func printRescans(out io.Writer, rescans []types.Rescan, now time.Time) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPREFIX\tPRIORITY\tDEADLINE\tCREATED\tSTATUS")
+	for _, r := range rescans {
+		deadline := "-"
+		if !r.Deadline.IsZero() {
+			deadline = r.Deadline.Format(time.RFC3339)
+		}
+		status := "queued"
+		switch {
+		case !r.Done.IsZero():
+			status = "done"
+		case !r.Taken.IsZero():
+			status = "running"
+		case !r.Deadline.IsZero() && now.After(r.Deadline):
+			status = "expired"
+		}
+		fmt.Fprintf(w, "%d\t%s/%d\t%d\t%s\t%s\t%s\n", r.ID, utils.IPToStr(r.Start), r.Prefix, r.Priority,
+			deadline, r.Created.Format(time.RFC3339), status)
+	}
+	w.Flush()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apsdehal/go-logger"
+	"github.com/golang/mock/gomock"
+	"github.com/nanorobocop/worldping/mocks"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+type mockQueueDB struct {
+	*mocks.MockDB
+	*mocks.MockRescanQueue
+}
+
+func TestGetTasksRescan(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockDB := mocks.NewMockDB(mockCtrl)
+	mockQueue := mocks.NewMockRescanQueue(mockCtrl)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockEnv := &envStruct{dbConn: mockQueueDB{mockDB, mockQueue}, ctx: ctx}
+	mockEnv.log, _ = logger.New("worldping", 0, os.Stdout)
+
+	rescan := types.Rescan{ID: 7, Start: 3221225984, Prefix: 30, Priority: 1} // 192.0.2.0/30
+	gomock.InOrder(
+		mockQueue.EXPECT().NextRescan().Return(rescan, true, nil),
+		mockQueue.EXPECT().FinishRescan(int64(7)).Return(nil),
+		mockQueue.EXPECT().NextRescan().Return(types.Rescan{}, false, errors.New("some error")),
+	)
+	mockDB.EXPECT().GetOldestIP().Return(uint32(1<<24), nil).Times(1)
+	mockDB.EXPECT().UnitSize().Return(uint32(1 << 24)).AnyTimes()
+
+	tasksCh := make(chan types.Task)
+	go mockEnv.getTasks(tasksCh)
+
+	// queued prefix goes first, sweep continues after queue error
+	expected := []uint32{3221225984, 3221225985, 3221225986, 3221225987, 1 << 24, 1<<24 + 1}
+	for i, exp := range expected {
+		if actual := <-tasksCh; actual.IP != exp {
+			t.Errorf("Task %d FAILED: %d (actual) != %d (expected)", i, actual.IP, exp)
+		}
+	}
+}
+
+func TestGetTasksRescanDrain(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockDB := mocks.NewMockDB(mockCtrl)
+	mockQueue := mocks.NewMockRescanQueue(mockCtrl)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockEnv := &envStruct{dbConn: mockQueueDB{mockDB, mockQueue}, ctx: ctx, drainCh: make(chan struct{}), checkpointCh: make(chan uint32, 1)}
+	mockEnv.log, _ = logger.New("worldping", 0, os.Stdout)
+
+	rescan := types.Rescan{ID: 7, Start: 3221225984, Prefix: 30} // 192.0.2.0/30
+	mockQueue.EXPECT().NextRescan().Return(rescan, true, nil).Times(1)
+	mockDB.EXPECT().GetOldestIP().Return(uint32(1<<24), nil).Times(1)
+	mockDB.EXPECT().UnitSize().Return(uint32(1 << 24)).AnyTimes()
+
+	tasksCh := make(chan types.Task)
+	go mockEnv.getTasks(tasksCh)
+	if task := <-tasksCh; task.IP != rescan.Start {
+		t.Fatalf("FAILED: task %d (actual) != %d (expected)", task.IP, rescan.Start)
+	}
+
+	// prefix interrupted by drain is given back to queue
+	released := make(chan struct{})
+	mockQueue.EXPECT().ReleaseRescan(int64(7)).DoAndReturn(func(int64) error {
+		close(released)
+		return nil
+	}).Times(1)
+	mockEnv.drain(cancel)
+	for range tasksCh {
+	}
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Errorf("FAILED: prefix was not released")
+	}
+}
+
+func TestSendTasksLastAddress(t *testing.T) {
+	mockEnv := &envStruct{ctx: context.Background()}
+	mockEnv.log, _ = logger.New("worldping", 0, os.Stdout)
+
+	tasksCh := make(chan types.Task, 2)
+	if _, ok := mockEnv.sendTasks(tasksCh, 1<<32-2, 1<<32-1, nil); !ok {
+		t.Fatalf("FAILED: sendTasks interrupted")
+	}
+	if len(tasksCh) != 2 {
+		t.Errorf("FAILED: %d tasks sent instead of 2", len(tasksCh))
+	}
+}
+
+func TestParseRescan(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		args     []string
+		expected types.Rescan
+		err      bool
+	}{
+		{args: []string{"192.0.2.0/24"}, expected: types.Rescan{Start: 3221225984, Prefix: 24}},
+		{args: []string{"192.0.2.77/24", "-priority", "5", "-deadline", "1h"},
+			expected: types.Rescan{Start: 3221225984, Prefix: 24, Priority: 5, Deadline: now.Add(time.Hour)}},
+		{args: []string{"10.0.0.0/8", "-priority=-1"}, expected: types.Rescan{Start: 10 << 24, Prefix: 8, Priority: -1}},
+		{args: nil, err: true},
+		{args: []string{"192.0.2.1"}, err: true},
+		{args: []string{"2001:db8::/32"}, err: true},
+		{args: []string{"192.0.2.0/24", "-deadline", "-1h"}, err: true},
+		{args: []string{"192.0.2.0/24", "-unknown"}, err: true},
+	}
+
+	for i, test := range tests {
+		actual, err := parseRescan(test.args, now)
+		if (err != nil) != test.err {
+			t.Errorf("Test %d FAILED: unexpected error %v", i, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("Test %d FAILED: %+v (actual) != %+v (expected)", i, actual, test.expected)
+		}
+	}
+}
+
+func TestPrintRescans(t *testing.T) {
+	now := time.Now()
+	rescans := []types.Rescan{
+		{ID: 1, Start: 3221225984, Prefix: 24, Priority: 5, Created: now},
+		{ID: 2, Start: 10 << 24, Prefix: 8, Created: now, Taken: now},
+		{ID: 3, Start: 10 << 24, Prefix: 8, Created: now, Deadline: now.Add(-time.Minute)},
+		{ID: 4, Start: 10 << 24, Prefix: 8, Created: now, Taken: now, Done: now},
+	}
+
+	var b bytes.Buffer
+	printRescans(&b, rescans, now)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	statuses := []string{"queued", "running", "expired", "done"}
+	if len(lines) != len(statuses)+1 || !strings.Contains(lines[1], "192.0.2.0/24") {
+		t.Fatalf("FAILED: wrong table:\n%s", b.String())
+	}
+	for i, status := range statuses {
+		if !strings.HasSuffix(lines[i+1], status) {
+			t.Errorf("FAILED: line %q should have status %s", lines[i+1], status)
+		}
+	}
+}
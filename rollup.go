@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nanorobocop/worldping/db"
+	"github.com/nanorobocop/worldping/pkg/types"
+	"github.com/nanorobocop/worldping/pkg/utils"
+)
+
+// rollup rebuilds aggregates of results per /24 and /16 or prints them
+func (env *envStruct) rollup(args []string) {
+	store, ok := env.dbConn.(db.RollupStore)
+	if !ok {
+		env.log.Fatalf("Rollups are not supported by database")
+	}
+
+	if len(args) == 0 {
+		env.log.Fatalf("Rollup subcommand is missing (should be rebuild or show)")
+	}
+	switch args[0] {
+	case "rebuild":
+		started := time.Now()
+		if err := store.RebuildRollups(); err != nil {
+			env.log.Fatalf("Cannot rebuild rollups: %v", err)
+		}
+		env.log.Noticef("Rollups rebuilt in %v", time.Since(started))
+	case "show":
+		if len(args) != 2 {
+			env.log.Fatalf("Prefix is missing (e.g. rollup show 8.8.0.0/16)")
+		}
+		prefix, start, end, err := parseRollupPrefix(args[1])
+		if err != nil {
+			env.log.Fatalf("Wrong prefix: %v", err)
+		}
+		rollups, err := store.GetRollups(prefix, start, end)
+		if err != nil {
+			env.log.Fatalf("Cannot get rollups: %v", err)
+		}
+		printRollups(os.Stdout, rollups)
+	default:
+		env.log.Fatalf("Unknown rollup subcommand %q (should be rebuild or show)", args[0])
+	}
+}
+
+// parseRollupPrefix parses IPv4 prefix and returns range of rollups shown for it:
+// /24 rollups for prefixes of /16 and longer, /16 rollups for shorter ones
+func parseRollupPrefix(s string) (prefix int, start, end uint32, err error) {
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	ip := ipNet.IP.To4()
+	if ip == nil {
+		return 0, 0, 0, fmt.Errorf("%s is not IPv4 prefix", s)
+	}
+	ones, _ := ipNet.Mask.Size()
+	start = uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+	end = start | uint32(1<<uint(32-ones)-1)
+
+	prefix = db.Rollup24
+	if ones < db.Rollup16 {
+		prefix = db.Rollup16
+	}
+	return prefix, start &^ (1<<uint(32-prefix) - 1), end, nil
+}
+
+// printRollups writes table of rollups
+func printRollups(out io.Writer, rollups []types.Rollup) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PREFIX\tPROBED\tRESPONSIVE\tRTT\tLAST SCANNED")
+	for _, r := range rollups {
+		rtt := "-"
+		if r.RTT != 0 {
+			rtt = r.RTT.Round(time.Microsecond).String()
+		}
+		fmt.Fprintf(w, "%s/%d\t%d\t%d\t%s\t%s\n", utils.IPToStr(r.Start), r.Prefix, r.Probed, r.Responsive, rtt,
+			r.LastScanned.Format(time.RFC3339))
+	}
+	w.Flush()
+}
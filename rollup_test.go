@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func TestParseRollupPrefix(t *testing.T) {
+	tests := []struct {
+		s          string
+		prefix     int
+		start, end uint32
+	}{
+		{s: "8.8.0.0/16", prefix: 24, start: 134742016, end: 134807551},
+		{s: "8.8.8.8/32", prefix: 24, start: 134744064, end: 134744072},
+		{s: "10.0.0.0/8", prefix: 16, start: 167772160, end: 184549375},
+		{s: "0.0.0.0/0", prefix: 16, start: 0, end: 4294967295},
+	}
+	for _, test := range tests {
+		prefix, start, end, err := parseRollupPrefix(test.s)
+		if err != nil || prefix != test.prefix || start != test.start || end != test.end {
+			t.Errorf("FAILED: %s parsed as /%d [%d, %d], %v", test.s, prefix, start, end, err)
+		}
+	}
+	if _, _, _, err := parseRollupPrefix("2001:db8::/32"); err == nil {
+		t.Errorf("FAILED: IPv6 prefix should not be parsed")
+	}
+}
+
+func TestPrintRollups(t *testing.T) {
+	rollups := []types.Rollup{
+		{Start: 134744064, Prefix: 24, Probed: 256, Responsive: 3, RTT: 1500 * time.Microsecond, LastScanned: time.Now()},
+		{Start: 134744320, Prefix: 24, Probed: 256},
+	}
+
+	var b bytes.Buffer
+	printRollups(&b, rollups)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "8.8.8.0/24") || !strings.Contains(lines[1], "1.5ms") ||
+		!strings.Contains(lines[2], " - ") {
+		t.Errorf("FAILED: wrong table:\n%s", b.String())
+	}
+}
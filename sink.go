@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nanorobocop/worldping/pkg/sink"
+)
+
+var resultsFile = os.Getenv("RESULTS_FILE") // results are written to file too if set, "-" for stdout
+var resultsFormat = getEnv("RESULTS_FORMAT", sink.JSONL)
+var resultsGzip, resultsGzipErr = strconv.ParseBool(getEnv("RESULTS_GZIP", "false"))
+var resultsMaxSize, resultsMaxSizeErr = strconv.ParseInt(getEnv("RESULTS_MAX_SIZE_MB", "0"), 10, 64)
+var resultsMaxAge, resultsMaxAgeErr = time.ParseDuration(getEnv("RESULTS_MAX_AGE", "0s"))
+
+var webhookURL = os.Getenv("WEBHOOK_URL") // results are posted as JSON too if set
+var webhookBatchSize, webhookBatchSizeErr = strconv.Atoi(getEnv("WEBHOOK_BATCH_SIZE", "1000"))
+var webhookTimeout, webhookTimeoutErr = time.ParseDuration(getEnv("WEBHOOK_TIMEOUT", "10s"))
+var sinkQueue, sinkQueueErr = strconv.Atoi(getEnv("SINK_QUEUE", "8"))
+var sinkRetries, sinkRetriesErr = strconv.Atoi(getEnv("SINK_RETRIES", "3"))
+
+// openResultsFile opens file sink for results (if configured)
+func (env *envStruct) openResultsFile() {
+	if resultsFile == "" {
+		return
+	}
+	if resultsGzipErr != nil {
+		env.log.Fatalf("Wrong value RESULTS_GZIP: %v", getEnv("RESULTS_GZIP", ""))
+	}
+	if resultsMaxSizeErr != nil || resultsMaxSize < 0 {
+		env.log.Fatalf("Wrong value RESULTS_MAX_SIZE_MB: %v", getEnv("RESULTS_MAX_SIZE_MB", ""))
+	}
+	if resultsMaxAgeErr != nil || resultsMaxAge < 0 {
+		env.log.Fatalf("Wrong value RESULTS_MAX_AGE: %v", getEnv("RESULTS_MAX_AGE", ""))
+	}
+
+	env.resultsFile = &sink.File{
+		Path:    resultsFile,
+		Format:  resultsFormat,
+		Gzip:    resultsGzip,
+		MaxSize: resultsMaxSize << 20,
+		MaxAge:  resultsMaxAge,
+	}
+	if err := env.resultsFile.Open(); err != nil {
+		env.log.Fatalf("Cannot open results file %s: %v", resultsFile, err)
+	}
+}
+
+// Names of sinks in metrics and logs
+const (
+	dbSink      = "db"
+	fileSink    = "file"
+	webhookSink = "webhook"
+)
+
+// newFanout starts delivery of results to database and to file and webhook (if configured).
+// Database is written by DB_WRITERS writers and blocks when DB_QUEUE batches are queued,
+// its failed batches are spooled. Other sinks get SINK_QUEUE batches queued at most,
+// batch is dropped when queue is full or write fails SINK_RETRIES times.
+func (env *envStruct) newFanout() *sink.Fanout {
+	sinks := &sink.Fanout{Errorf: env.log.Errorf, Warningf: env.log.Warningf}
+	sinks.Add(sink.Func(env.writeDB), sink.Options{Name: dbSink, Workers: dbWriters, Queue: dbQueue, Block: true})
+	if env.resultsFile != nil {
+		sinks.Add(env.resultsFile, sink.Options{Name: fileSink, Queue: sinkQueue, Retries: sinkRetries})
+	}
+	if webhookURL != "" {
+		webhook := &sink.Webhook{URL: webhookURL, BatchSize: webhookBatchSize, Client: &http.Client{Timeout: webhookTimeout}}
+		sinks.Add(webhook, sink.Options{Name: webhookSink, Queue: sinkQueue, Retries: sinkRetries})
+	}
+	return sinks
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apsdehal/go-logger"
+	"github.com/golang/mock/gomock"
+	"github.com/nanorobocop/worldping/mocks"
+	"github.com/nanorobocop/worldping/pkg/sink"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func TestSendStatSinks(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	posted := make(chan int, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Results []json.RawMessage }
+		json.NewDecoder(r.Body).Decode(&req)
+		posted <- len(req.Results)
+	}))
+	defer srv.Close()
+	url := webhookURL
+	webhookURL = srv.URL
+	defer func() { webhookURL = url }()
+
+	mockDB := mocks.NewMockDB(mockCtrl)
+	mockEnv := &envStruct{dbConn: mockDB}
+	mockEnv.log, _ = logger.New("worldping", 0, os.Stdout)
+	path := filepath.Join(t.TempDir(), "results.csv")
+	mockEnv.resultsFile = &sink.File{Path: path, Format: sink.CSV}
+	if err := mockEnv.resultsFile.Open(); err != nil {
+		t.Fatalf("Cannot open results file: %v", err)
+	}
+
+	// results go to database, file and webhook
+	mockDB.EXPECT().Save(types.Tasks{{IP: 1, Ping: true}, {IP: 2}}).Return(nil).Times(1)
+	resultCh := make(chan types.Task)
+	var cancel context.CancelFunc
+	mockEnv.ctx, cancel = context.WithCancel(context.Background())
+	mockEnv.wg.Add(1)
+	go mockEnv.sendStat(resultCh, flushInterval)
+	resultCh <- types.Task{IP: 1, Ping: true}
+	resultCh <- types.Task{IP: 2}
+	cancel()
+	mockEnv.wg.Wait()
+	mockEnv.resultsFile.Close()
+
+	b, _ := ioutil.ReadFile(path)
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[1], "0.0.0.1,,true") {
+		t.Errorf("FAILED: wrong results file %q", b)
+	}
+	if n := <-posted; n != 2 {
+		t.Errorf("FAILED: %d results posted instead of 2", n)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nanorobocop/worldping/db"
+	"github.com/nanorobocop/worldping/pkg/coordinator"
+	"github.com/nanorobocop/worldping/pkg/spool"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+var spoolDir = getEnv("SPOOL_DIR", "") // spool is disabled if empty
+var spoolQuota, spoolQuotaErr = strconv.ParseInt(getEnv("SPOOL_QUOTA_MB", "1024"), 10, 64)
+
+const (
+	spoolRetryMin = time.Second
+	spoolRetryMax = 5 * time.Minute
+)
+
+// openSpool opens spool with batches left from previous run (if enabled)
+func (env *envStruct) openSpool() {
+	if spoolDir == "" {
+		return
+	}
+	if spoolQuotaErr != nil || spoolQuota < 0 {
+		env.log.Fatalf("Wrong value SPOOL_QUOTA_MB: %v", getEnv("SPOOL_QUOTA_MB", ""))
+	}
+
+	var err error
+	if env.spool, err = spool.Open(spoolDir, spoolQuota<<20); err != nil {
+		env.log.Fatalf("Cannot open spool %s: %v", spoolDir, err)
+	}
+	if n := env.spool.Len(); n > 0 {
+		env.log.Noticef("Found %d batches (%d bytes) in spool, replaying", n, env.spool.Size())
+	}
+}
+
+// saveResults saves batch to DB. If it fails, batch is written to spool.
+// While spool is not empty, new batches go to spool too, so they are saved in order.
+func (env *envStruct) saveResults(batch types.Batch) {
+	if env.spool != nil && env.spool.Len() > 0 {
+		env.spoolResults(batch)
+		return
+	}
+	if err := env.save(batch); err != nil {
+		env.log.Errorf("Problem at saving result to database: %s", err)
+		if env.spool != nil {
+			env.spoolResults(batch)
+		}
+	}
+}
+
+// save saves batch to DB. Identified batch is saved once if DB supports it,
+// so batch committed before save failed (e.g. on timeout) is not saved again by retry.
+func (env *envStruct) save(batch types.Batch) error {
+	store, ok := env.dbConn.(db.BatchStore)
+	if !ok || batch.ID.IsZero() {
+		return env.dbConn.Save(batch.Results)
+	}
+	duplicate, err := store.SaveBatch(batch)
+	if duplicate {
+		env.log.Noticef("Batch %s was saved before, skipping", batch.ID)
+	}
+	return err
+}
+
+// workerName identifies worker in batch IDs: ID assigned by coordinator, WORKER_ID or hostname
+func (env *envStruct) workerName() string {
+	if c, ok := env.dbConn.(*coordinator.Client); ok && c.ID != "" {
+		return c.ID
+	}
+	if workerID != "" {
+		return workerID
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+func (env *envStruct) spoolResults(batch types.Batch) {
+	if err := env.spool.Put(batch); err != nil {
+		env.log.Errorf("Cannot write %d results to spool, dropping them: %v", len(batch.Results), err)
+		return
+	}
+	env.log.Noticef("Saved %d results to spool (%d batches, %d bytes)", len(batch.Results), env.spool.Len(), env.spool.Size())
+}
+
+// replaySpool saves spooled batches to DB until shutdown.
+// Attempts are retried with exponential backoff while DB is unavailable.
+func (env *envStruct) replaySpool() {
+	backoff := spoolRetryMin
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-env.ctx.Done():
+			return
+		}
+
+		n, err := env.spool.Replay(env.save)
+		if n > 0 {
+			env.log.Noticef("Replayed %d batches from spool, %d left", n, env.spool.Len())
+		}
+		if err != nil {
+			env.log.Errorf("Cannot replay spool (retry in %v): %v", backoff, err)
+			timer.Reset(backoff)
+			if backoff *= 2; backoff > spoolRetryMax {
+				backoff = spoolRetryMax
+			}
+			continue
+		}
+		backoff = spoolRetryMin
+		timer.Reset(spoolRetryMin)
+	}
+}
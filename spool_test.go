@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/apsdehal/go-logger"
+	"github.com/golang/mock/gomock"
+	"github.com/nanorobocop/worldping/mocks"
+	"github.com/nanorobocop/worldping/pkg/spool"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+func TestSaveResultsSpool(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockDB := mocks.NewMockDB(mockCtrl)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockEnv := &envStruct{dbConn: mockDB, ctx: ctx}
+	mockEnv.log, _ = logger.New("worldping", 0, os.Stdout)
+
+	var err error
+	if mockEnv.spool, err = spool.Open(t.TempDir(), 0); err != nil {
+		t.Fatalf("Cannot open spool: %v", err)
+	}
+
+	first := types.Batch{Results: types.Tasks{{IP: 1}}}
+	second := types.Batch{Results: types.Tasks{{IP: 2}}}
+
+	// failed batch is spooled, next one goes after it without trying DB
+	mockDB.EXPECT().Save(first.Results).Return(errors.New("some error")).Times(1)
+	mockEnv.saveResults(first)
+	mockEnv.saveResults(second)
+	if mockEnv.spool.Len() != 2 {
+		t.Fatalf("FAILED: %d batches in spool instead of 2", mockEnv.spool.Len())
+	}
+
+	// replay retries after error and keeps order
+	saved := make(chan types.Tasks, 2)
+	gomock.InOrder(
+		mockDB.EXPECT().Save(first.Results).Return(errors.New("some error")),
+		mockDB.EXPECT().Save(first.Results).DoAndReturn(func(b types.Tasks) error { saved <- b; return nil }),
+		mockDB.EXPECT().Save(second.Results).DoAndReturn(func(b types.Tasks) error { saved <- b; return nil }),
+	)
+	go mockEnv.replaySpool()
+
+	for _, exp := range []types.Tasks{first.Results, second.Results} {
+		select {
+		case b := <-saved:
+			if b[0].IP != exp[0].IP {
+				t.Errorf("FAILED: replayed %+v instead of %+v", b, exp)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("FAILED: spool was not replayed")
+		}
+	}
+}
+
+type mockBatchDB struct {
+	*mocks.MockDB
+	*mocks.MockBatchStore
+}
+
+func TestSaveBatch(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockDB := mockBatchDB{mocks.NewMockDB(mockCtrl), mocks.NewMockBatchStore(mockCtrl)}
+	mockEnv := &envStruct{dbConn: mockDB}
+	mockEnv.log, _ = logger.New("worldping", 0, os.Stdout)
+
+	// identified batch is saved once, retry is reported as duplicate
+	batch := types.Batch{ID: types.BatchID{Worker: "worker-1", Started: time.Now(), Seq: 1}, Results: types.Tasks{{IP: 1}}}
+	gomock.InOrder(
+		mockDB.MockBatchStore.EXPECT().SaveBatch(batch).Return(false, errors.New("timeout")),
+		mockDB.MockBatchStore.EXPECT().SaveBatch(batch).Return(true, nil),
+	)
+	if err := mockEnv.save(batch); err == nil {
+		t.Errorf("FAILED: save error expected")
+	}
+	if err := mockEnv.save(batch); err != nil {
+		t.Errorf("FAILED: duplicate should not be error: %v", err)
+	}
+
+	// batch without ID is saved as is
+	mockDB.MockDB.EXPECT().Save(batch.Results).Return(nil).Times(1)
+	if err := mockEnv.save(types.Batch{Results: batch.Results}); err != nil {
+		t.Errorf("FAILED: save: %v", err)
+	}
+}
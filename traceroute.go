@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nanorobocop/worldping/db"
+	"github.com/nanorobocop/worldping/pkg/probe"
+	"github.com/nanorobocop/worldping/pkg/types"
+	"github.com/nanorobocop/worldping/pkg/utils"
+)
+
+var traceMethod = getEnv("TRACE_METHOD", probe.TraceICMP)
+var traceMaxTTL, _ = strconv.Atoi(getEnv("TRACE_MAX_TTL", "30"))
+var traceConcurrency, _ = strconv.Atoi(getEnv("TRACE_CONCURRENCY", "100"))
+
+// Tracer interface
+type Tracer interface {
+	Trace(net.IP) ([]probe.Hop, error)
+	Close()
+}
+
+// traceroute runs Paris traceroute to one responsive address per /24
+// from the latest results and stores hops
+func (env *envStruct) traceroute() {
+	store, ok := env.dbConn.(db.TraceStore)
+	if !ok {
+		env.log.Fatalf("Database does not support storing traceroutes")
+	}
+
+	if traceMaxTTL <= 0 || traceMaxTTL > 255 {
+		env.log.Fatalf("Wrong value TRACE_MAX_TTL=%v (should be between 1 and 255)", traceMaxTTL)
+	}
+	if traceConcurrency <= 0 {
+		env.log.Fatalf("Wrong value TRACE_CONCURRENCY=%v (should be positive)", traceConcurrency)
+	}
+
+	tracer, err := probe.NewTracer("0.0.0.0", traceMethod, traceMaxTTL, 1*time.Second)
+	if err != nil {
+		env.log.Fatalf("Cannot initialize tracer: %v", err)
+	}
+	defer tracer.Close()
+
+	env.traceAll(store, tracer)
+}
+
+func (env *envStruct) traceAll(store db.TraceStore, tracer Tracer) {
+	targets, err := store.GetRepresentatives()
+	if err != nil {
+		env.log.Errorf("Cannot get traceroute targets: %v", err)
+		return
+	}
+	env.log.Noticef("Tracing %d targets (%s)", len(targets), traceMethod)
+
+	var wg sync.WaitGroup
+	guard := make(chan struct{}, traceConcurrency)
+
+loop:
+	for i, ip := range targets {
+		select {
+		case guard <- struct{}{}:
+		case <-env.ctx.Done():
+			env.log.Noticef("Received signal for shutdown.")
+			break loop
+		}
+
+		if i%1000 == 0 {
+			env.log.Noticef("Tracing target %d of %d: %s", i, len(targets), utils.IPToStr(ip))
+		}
+
+		wg.Add(1)
+		go func(ip uint32) {
+			defer wg.Done()
+			env.trace(store, tracer, ip)
+			<-guard
+		}(ip)
+	}
+
+	wg.Wait()
+	env.log.Notice("Tracing finished")
+}
+
+func (env *envStruct) trace(store db.TraceStore, tracer Tracer, ip uint32) {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, ip)
+
+	hops, err := tracer.Trace(net.IP(buf))
+	if err != nil {
+		env.log.Errorf("Cannot trace %s: %v", utils.IPToStr(ip), err)
+		return
+	}
+
+	trace := types.Trace{Target: ip, Method: traceMethod, Hops: make([]types.Hop, len(hops))}
+	for i, hop := range hops {
+		trace.Hops[i] = types.Hop{TTL: uint8(hop.TTL), RTT: hop.RTT}
+		if router := hop.Router.To4(); router != nil {
+			trace.Hops[i].Router = binary.BigEndian.Uint32(router)
+		}
+	}
+	env.log.Debugf("trace: %s : %d hops", utils.IPToStr(ip), len(hops))
+
+	if err := store.SaveTrace(trace); err != nil {
+		env.log.Errorf("Problem at saving traceroute to database: %s", err)
+	}
+}
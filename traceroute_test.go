@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/apsdehal/go-logger"
+	"github.com/golang/mock/gomock"
+	"github.com/nanorobocop/worldping/mocks"
+	"github.com/nanorobocop/worldping/pkg/probe"
+	"github.com/nanorobocop/worldping/pkg/types"
+)
+
+type mockTracer struct {
+	hops    []probe.Hop
+	mockErr error
+}
+
+func (tr mockTracer) Trace(net.IP) ([]probe.Hop, error) {
+	return tr.hops, tr.mockErr
+}
+
+func (tr mockTracer) Close() {}
+
+func TestTraceAll(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockStore := mocks.NewMockTraceStore(mockCtrl)
+	mockEnv := &envStruct{ctx: context.Background()}
+	mockEnv.log, _ = logger.New("worldping", 0, os.Stdout)
+
+	tracer := mockTracer{hops: []probe.Hop{
+		{TTL: 1, Router: net.IPv4(192, 0, 2, 1), RTT: time.Millisecond},
+		{TTL: 2},
+		{TTL: 3, Router: net.IPv4(8, 8, 8, 8), RTT: 2 * time.Millisecond},
+	}}
+	expHops := []types.Hop{
+		{TTL: 1, Router: 3221225985, RTT: time.Millisecond},
+		{TTL: 2},
+		{TTL: 3, Router: 134744072, RTT: 2 * time.Millisecond},
+	}
+
+	mockStore.EXPECT().GetRepresentatives().Return([]uint32{134744072, 16843009}, nil).Times(1)
+	mockStore.EXPECT().SaveTrace(types.Trace{Target: 134744072, Method: traceMethod, Hops: expHops}).Return(nil).Times(1)
+	mockStore.EXPECT().SaveTrace(types.Trace{Target: 16843009, Method: traceMethod, Hops: expHops}).Return(errors.New("some error")).Times(1)
+
+	mockEnv.traceAll(mockStore, tracer)
+}
+
+func TestTraceAllErrors(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockStore := mocks.NewMockTraceStore(mockCtrl)
+	mockEnv := &envStruct{ctx: context.Background()}
+	mockEnv.log, _ = logger.New("worldping", 0, os.Stdout)
+
+	// no targets - nothing to save
+	mockStore.EXPECT().GetRepresentatives().Return(nil, errors.New("some error")).Times(1)
+	mockEnv.traceAll(mockStore, mockTracer{})
+
+	// tracing failed - nothing to save
+	mockStore.EXPECT().GetRepresentatives().Return([]uint32{1}, nil).Times(1)
+	mockEnv.traceAll(mockStore, mockTracer{mockErr: errors.New("some error")})
+}